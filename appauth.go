@@ -0,0 +1,163 @@
+package main
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/google/go-github/github"
+	"golang.org/x/oauth2"
+)
+
+// githubAPIBaseURL is a var rather than a const so tests can point it at a
+// fake server.
+var githubAPIBaseURL = "https://api.github.com"
+
+// GithubClients resolves a *github.Client to act as for a given
+// installation, so that a single running instance of the bot can serve
+// many orgs/repos without any one of them handing over a personal access
+// token.
+type GithubClients interface {
+	Client(installationID int64) (*github.Client, error)
+	// Invalidate drops any cached token for the installation, forcing
+	// the next Client call to mint a fresh one.
+	Invalidate(installationID int64)
+}
+
+// staticGithubClients always returns the same client regardless of
+// installation. Used when the bot is configured with a single shared
+// access token rather than GitHub App credentials.
+type staticGithubClients struct {
+	client *github.Client
+}
+
+func NewStaticGithubClients(accessToken string) GithubClients {
+	return &staticGithubClients{client: initGithubClient(accessToken)}
+}
+
+func (s *staticGithubClients) Client(installationID int64) (*github.Client, error) {
+	return s.client, nil
+}
+
+func (s *staticGithubClients) Invalidate(installationID int64) {}
+
+type installationToken struct {
+	Token     string
+	ExpiresAt time.Time
+}
+
+func (t installationToken) expiringSoon() bool {
+	return time.Now().Add(time.Minute).After(t.ExpiresAt)
+}
+
+// appGithubClients mints and caches short-lived installation access tokens
+// for a GitHub App, per
+// https://docs.github.com/en/apps/creating-github-apps/authenticating-with-a-github-app/authenticating-as-a-github-app-installation
+type appGithubClients struct {
+	appID      int64
+	privateKey *rsa.PrivateKey
+
+	mu     sync.Mutex
+	tokens map[int64]installationToken
+}
+
+func NewAppGithubClients(auth AppAuth) (GithubClients, error) {
+	keyBytes, err := ioutil.ReadFile(auth.PrivateKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the GitHub App private key: %s", err)
+	}
+	block, _ := pem.Decode(keyBytes)
+	if block == nil {
+		return nil, errors.New("failed to decode the GitHub App private key PEM")
+	}
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the GitHub App private key: %s", err)
+	}
+	return &appGithubClients{
+		appID:      auth.AppID,
+		privateKey: key,
+		tokens:     make(map[int64]installationToken),
+	}, nil
+}
+
+func (a *appGithubClients) Client(installationID int64) (*github.Client, error) {
+	token, err := a.installationAccessToken(installationID)
+	if err != nil {
+		return nil, err
+	}
+	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})
+	return github.NewClient(oauth2.NewClient(oauth2.NoContext, ts)), nil
+}
+
+func (a *appGithubClients) Invalidate(installationID int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.tokens, installationID)
+}
+
+func (a *appGithubClients) installationAccessToken(installationID int64) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if token, ok := a.tokens[installationID]; ok && !token.expiringSoon() {
+		return token.Token, nil
+	}
+	appJWT, err := a.signedJWT()
+	if err != nil {
+		return "", err
+	}
+	token, err := requestInstallationToken(installationID, appJWT)
+	if err != nil {
+		return "", err
+	}
+	a.tokens[installationID] = token
+	return token.Token, nil
+}
+
+// signedJWT builds the short-lived JWT GitHub requires to identify the App
+// itself, as opposed to one of its installations.
+func (a *appGithubClients) signedJWT() (string, error) {
+	now := time.Now()
+	claims := jwt.StandardClaims{
+		Issuer:    fmt.Sprintf("%d", a.appID),
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(10 * time.Minute).Unix(),
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	return token.SignedString(a.privateKey)
+}
+
+func requestInstallationToken(installationID int64, appJWT string) (installationToken, error) {
+	url := fmt.Sprintf("%s/app/installations/%d/access_tokens", githubAPIBaseURL, installationID)
+	req, err := http.NewRequest("POST", url, nil)
+	if err != nil {
+		return installationToken{}, err
+	}
+	req.Header.Set("Authorization", "Bearer "+appJWT)
+	req.Header.Set("Accept", "application/vnd.github.machine-man-preview+json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return installationToken{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return installationToken{}, fmt.Errorf("failed to create an installation access token: %s: %s", resp.Status, body)
+	}
+	var result struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return installationToken{}, err
+	}
+	return installationToken{Token: result.Token, ExpiresAt: result.ExpiresAt}, nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func newTestAppGithubClients(t *testing.T) *appGithubClients {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating test key: %s", err)
+	}
+	return &appGithubClients{appID: 42, privateKey: key, tokens: make(map[int64]installationToken)}
+}
+
+// withFakeGithubAPI points githubAPIBaseURL at a test server running handler
+// for the duration of the test.
+func withFakeGithubAPI(t *testing.T, handler http.HandlerFunc) {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	original := githubAPIBaseURL
+	githubAPIBaseURL = server.URL
+	t.Cleanup(func() { githubAPIBaseURL = original })
+}
+
+func respondWithToken(w http.ResponseWriter, token string) {
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"token":      token,
+		"expires_at": time.Now().Add(time.Hour),
+	})
+}
+
+func TestInstallationAccessTokenReusesAnUnexpiredToken(t *testing.T) {
+	clients := newTestAppGithubClients(t)
+	requests := 0
+	withFakeGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		respondWithToken(w, "tok-1")
+	})
+
+	for i := 0; i < 2; i++ {
+		token, err := clients.installationAccessToken(123)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if token != "tok-1" {
+			t.Fatalf("token = %q, want tok-1", token)
+		}
+	}
+	if requests != 1 {
+		t.Fatalf("expected the cached token to be reused, got %d token requests", requests)
+	}
+}
+
+func TestInstallationAccessTokenReMintsOnceExpiringSoon(t *testing.T) {
+	clients := newTestAppGithubClients(t)
+	clients.tokens[123] = installationToken{Token: "stale", ExpiresAt: time.Now().Add(-time.Minute)}
+	requests := 0
+	withFakeGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		respondWithToken(w, "tok-fresh")
+	})
+
+	token, err := clients.installationAccessToken(123)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if token != "tok-fresh" {
+		t.Fatalf("token = %q, want tok-fresh", token)
+	}
+	if requests != 1 {
+		t.Fatalf("expected a fresh token to be minted, got %d token requests", requests)
+	}
+}
+
+func TestRequestInstallationTokenNon201(t *testing.T) {
+	withFakeGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("installation suspended"))
+	})
+
+	_, err := requestInstallationToken(123, "some-jwt")
+	if err == nil {
+		t.Fatal("expected an error for a non-201 response")
+	}
+	if !strings.Contains(err.Error(), "installation suspended") {
+		t.Errorf("error %q doesn't include the response body", err)
+	}
+}
+
+func TestSignedJWT(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating test key: %s", err)
+	}
+	clients := &appGithubClients{appID: 42, privateKey: key}
+
+	tokenString, err := clients.signedJWT()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	claims := &jwt.StandardClaims{}
+	parsed, err := jwt.ParseWithClaims(tokenString, claims, func(*jwt.Token) (interface{}, error) {
+		return &key.PublicKey, nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error parsing the signed JWT: %s", err)
+	}
+	if !parsed.Valid {
+		t.Fatal("expected the JWT to be valid")
+	}
+	if claims.Issuer != "42" {
+		t.Fatalf("issuer = %q, want 42", claims.Issuer)
+	}
+	if window := claims.ExpiresAt - claims.IssuedAt; window != 600 {
+		t.Fatalf("expiry window = %ds, want 600s", window)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/google/go-github/github"
+)
+
+type PullRequestReviewEvent struct {
+	Action        string
+	State         string
+	ReviewerLogin string
+	IssueNumber   int
+	HeadSHA       string
+	Repository    Repository
+}
+
+func (p PullRequestReviewEvent) Issue() Issue {
+	return Issue{
+		Number:     p.IssueNumber,
+		Repository: p.Repository,
+	}
+}
+
+func parsePullRequestReviewEvent(body []byte) (PullRequestReviewEvent, error) {
+	var message struct {
+		Action string `json:"action"`
+		Review struct {
+			State string `json:"state"`
+			User  struct {
+				Login string `json:"login"`
+			} `json:"user"`
+		} `json:"review"`
+		PullRequest struct {
+			Number int `json:"number"`
+			Head   struct {
+				SHA string `json:"sha"`
+			} `json:"head"`
+		} `json:"pull_request"`
+		Repository struct {
+			Name  string `json:"name"`
+			Owner struct {
+				Login string `json:"login"`
+			} `json:"owner"`
+			SSHURL string `json:"ssh_url"`
+		} `json:"repository"`
+	}
+	err := json.Unmarshal(body, &message)
+	if err != nil {
+		return PullRequestReviewEvent{}, err
+	}
+	return PullRequestReviewEvent{
+		Action:        message.Action,
+		State:         message.Review.State,
+		ReviewerLogin: message.Review.User.Login,
+		IssueNumber:   message.PullRequest.Number,
+		HeadSHA:       message.PullRequest.Head.SHA,
+		Repository: Repository{
+			Owner: message.Repository.Owner.Login,
+			Name:  message.Repository.Name,
+			URL:   message.Repository.SSHURL,
+		},
+	}, nil
+}
+
+func handlePullRequestReview(ctx context.Context, body []byte, conf Config, approvals *approvalTracker, repositories Repositories) Response {
+	event, err := parsePullRequestReviewEvent(body)
+	if err != nil {
+		return ErrorResponse{err, http.StatusInternalServerError, "Failed to parse the request's body"}
+	}
+	switch {
+	case event.Action == "dismissed":
+		count := approvals.revokeApproval(event.Repository, event.IssueNumber, event.HeadSHA, event.ReviewerLogin)
+		status := peerReviewStatusFor(count, conf.RequiredApprovals)
+		if errResp := setStatus(ctx, event.Repository, event.HeadSHA, status, repositories); errResp != nil {
+			return errResp
+		}
+		return SuccessResponse{}
+	case event.State == "approved":
+		status := approvalStatus(approvals, event.Repository, event.IssueNumber, event.HeadSHA, event.ReviewerLogin, conf.RequiredApprovals)
+		if errResp := setStatus(ctx, event.Repository, event.HeadSHA, status, repositories); errResp != nil {
+			return errResp
+		}
+		return SuccessResponse{}
+	case event.State == "changes_requested":
+		status := createPeerReviewStatus("failure", fmt.Sprintf("%s requested changes", event.ReviewerLogin))
+		if errResp := setStatus(ctx, event.Repository, event.HeadSHA, status, repositories); errResp != nil {
+			return errResp
+		}
+		return SuccessResponse{}
+	}
+	return SuccessResponse{"Not a review state I understand. Ignoring."}
+}
+
+// approvalStatus records login's approval of repository/number at headSHA
+// and returns the review/peer status that should result: success once
+// requiredApprovals distinct logins have approved the current head SHA,
+// pending otherwise.
+func approvalStatus(approvals *approvalTracker, repository Repository, number int, headSHA, login string, requiredApprovals int) *github.RepoStatus {
+	count := approvals.recordApproval(repository, number, headSHA, login)
+	return peerReviewStatusFor(count, requiredApprovals)
+}
+
+// peerReviewStatusFor turns a current approval count into the review/peer
+// status that should result: success once requiredApprovals distinct
+// logins have approved, pending otherwise.
+func peerReviewStatusFor(count, requiredApprovals int) *github.RepoStatus {
+	required := requiredApprovals
+	if required < 1 {
+		required = 1
+	}
+	if count >= required {
+		return createPeerReviewStatus("success", "This PR has been peer reviewed")
+	}
+	return createPeerReviewStatus("pending", fmt.Sprintf("%d/%d approvals", count, required))
+}
+
+// approvalTracker keeps track of which logins have approved a PR's
+// current head SHA, so that RequiredApprovals > 1 can be enforced across
+// both +1 comments and pull_request_review approvals. State for a PR is
+// dropped whenever its head SHA changes, since approvals of an old commit
+// don't carry over to a new one.
+type approvalTracker struct {
+	mu  sync.Mutex
+	prs map[string]*prApprovals
+}
+
+type prApprovals struct {
+	HeadSHA string
+	Logins  map[string]bool
+}
+
+func newApprovalTracker() *approvalTracker {
+	return &approvalTracker{prs: make(map[string]*prApprovals)}
+}
+
+func (t *approvalTracker) recordApproval(repository Repository, number int, headSHA, login string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key := approvalKey(repository, number)
+	pr, ok := t.prs[key]
+	if !ok || pr.HeadSHA != headSHA {
+		pr = &prApprovals{HeadSHA: headSHA, Logins: make(map[string]bool)}
+		t.prs[key] = pr
+	}
+	pr.Logins[login] = true
+	return len(pr.Logins)
+}
+
+// revokeApproval removes login's approval of repository/number at headSHA
+// and returns the number of approvals that remain. Only the dismissing
+// login is removed; approvals from other reviewers at the same head SHA
+// still count towards the threshold. If the tracked state belongs to a
+// different head SHA (e.g. the dismissal raced a new push), there's
+// nothing of login's to revoke, so the count is reported unchanged.
+func (t *approvalTracker) revokeApproval(repository Repository, number int, headSHA, login string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	pr, ok := t.prs[approvalKey(repository, number)]
+	if !ok || pr.HeadSHA != headSHA {
+		return 0
+	}
+	delete(pr.Logins, login)
+	return len(pr.Logins)
+}
+
+func approvalKey(repository Repository, number int) string {
+	return fmt.Sprintf("%s/%s#%d", repository.Owner, repository.Name, number)
+}
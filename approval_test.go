@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestParsePullRequestReviewEvent(t *testing.T) {
+	body := []byte(`{
+		"action": "submitted",
+		"review": {"state": "approved", "user": {"login": "alice"}},
+		"pull_request": {"number": 7, "head": {"sha": "headsha"}},
+		"repository": {"name": "r", "owner": {"login": "o"}, "ssh_url": "git@github.com:o/r.git"}
+	}`)
+
+	event, err := parsePullRequestReviewEvent(body)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := PullRequestReviewEvent{
+		Action:        "submitted",
+		State:         "approved",
+		ReviewerLogin: "alice",
+		IssueNumber:   7,
+		HeadSHA:       "headsha",
+		Repository:    Repository{Owner: "o", Name: "r", URL: "git@github.com:o/r.git"},
+	}
+	if event != want {
+		t.Fatalf("event = %+v, want %+v", event, want)
+	}
+}
+
+func TestPeerReviewStatusFor(t *testing.T) {
+	tests := []struct {
+		name              string
+		count             int
+		requiredApprovals int
+		wantState         string
+		wantDescription   string
+	}{
+		{name: "below threshold", count: 1, requiredApprovals: 2, wantState: "pending", wantDescription: "1/2 approvals"},
+		{name: "meets threshold", count: 2, requiredApprovals: 2, wantState: "success"},
+		{name: "exceeds threshold", count: 3, requiredApprovals: 2, wantState: "success"},
+		{name: "unconfigured required defaults to 1", count: 1, requiredApprovals: 0, wantState: "success"},
+		{name: "unconfigured required not yet met", count: 0, requiredApprovals: 0, wantState: "pending", wantDescription: "0/1 approvals"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status := peerReviewStatusFor(tt.count, tt.requiredApprovals)
+			if status.State == nil || *status.State != tt.wantState {
+				t.Fatalf("state = %v, want %q", status.State, tt.wantState)
+			}
+			if status.Context == nil || *status.Context != githubStatusPeerReviewContext {
+				t.Fatalf("context = %v, want %q", status.Context, githubStatusPeerReviewContext)
+			}
+			if tt.wantDescription != "" && (status.Description == nil || *status.Description != tt.wantDescription) {
+				t.Fatalf("description = %v, want %q", status.Description, tt.wantDescription)
+			}
+		})
+	}
+}
+
+func TestApprovalTrackerRecordApproval(t *testing.T) {
+	tracker := newApprovalTracker()
+	repo := Repository{Owner: "o", Name: "r"}
+
+	if count := tracker.recordApproval(repo, 1, "sha1", "alice"); count != 1 {
+		t.Fatalf("count = %d, want 1", count)
+	}
+	if count := tracker.recordApproval(repo, 1, "sha1", "bob"); count != 2 {
+		t.Fatalf("count = %d, want 2", count)
+	}
+	if count := tracker.recordApproval(repo, 1, "sha1", "alice"); count != 2 {
+		t.Fatalf("re-approving the same login should not double-count, got %d", count)
+	}
+}
+
+func TestApprovalTrackerResetsOnNewHeadSHA(t *testing.T) {
+	tracker := newApprovalTracker()
+	repo := Repository{Owner: "o", Name: "r"}
+
+	tracker.recordApproval(repo, 1, "sha1", "alice")
+	tracker.recordApproval(repo, 1, "sha1", "bob")
+
+	if count := tracker.recordApproval(repo, 1, "sha2", "carol"); count != 1 {
+		t.Fatalf("a new head SHA should drop prior approvals, got count %d", count)
+	}
+}
+
+func TestApprovalTrackerRevokeApproval(t *testing.T) {
+	tracker := newApprovalTracker()
+	repo := Repository{Owner: "o", Name: "r"}
+
+	tracker.recordApproval(repo, 1, "sha1", "alice")
+	tracker.recordApproval(repo, 1, "sha1", "bob")
+	tracker.recordApproval(repo, 1, "sha1", "carol")
+
+	if count := tracker.revokeApproval(repo, 1, "sha1", "bob"); count != 2 {
+		t.Fatalf("count after revoking bob = %d, want 2", count)
+	}
+	if count := tracker.recordApproval(repo, 1, "sha1", "dave"); count != 3 {
+		t.Fatalf("expected alice and carol's approvals to survive the dismissal, got count %d", count)
+	}
+}
+
+func TestApprovalTrackerRevokeApprovalAtStaleHeadSHAIsANoop(t *testing.T) {
+	tracker := newApprovalTracker()
+	repo := Repository{Owner: "o", Name: "r"}
+
+	tracker.recordApproval(repo, 1, "sha1", "alice")
+	tracker.recordApproval(repo, 1, "sha2", "bob")
+
+	if count := tracker.revokeApproval(repo, 1, "sha1", "alice"); count != 0 {
+		t.Fatalf("expected a stale-head-SHA dismissal to report 0, got %d", count)
+	}
+	if count := tracker.recordApproval(repo, 1, "sha2", "carol"); count != 2 {
+		t.Fatalf("expected bob's current approval to be untouched, got count %d", count)
+	}
+}
+
+func TestHandlePullRequestReviewApproved(t *testing.T) {
+	body := []byte(`{
+		"action": "submitted",
+		"review": {"state": "approved", "user": {"login": "alice"}},
+		"pull_request": {"number": 1, "head": {"sha": "headsha"}},
+		"repository": {"name": "r", "owner": {"login": "o"}}
+	}`)
+	approvals := newApprovalTracker()
+	repositories := &fakeRepositories{}
+	conf := Config{RequiredApprovals: 2}
+
+	resp := handlePullRequestReview(context.Background(), body, conf, approvals, repositories)
+	if _, ok := resp.(SuccessResponse); !ok {
+		t.Fatalf("expected a SuccessResponse, got %+v", resp)
+	}
+	status := repositories.lastStatus()
+	if status == nil || status.State == nil || *status.State != "pending" {
+		t.Fatalf("expected a pending review/peer status, got %+v", status)
+	}
+}
+
+func TestHandlePullRequestReviewChangesRequested(t *testing.T) {
+	body := []byte(`{
+		"action": "submitted",
+		"review": {"state": "changes_requested", "user": {"login": "alice"}},
+		"pull_request": {"number": 1, "head": {"sha": "headsha"}},
+		"repository": {"name": "r", "owner": {"login": "o"}}
+	}`)
+	approvals := newApprovalTracker()
+	repositories := &fakeRepositories{}
+
+	resp := handlePullRequestReview(context.Background(), body, Config{}, approvals, repositories)
+	if _, ok := resp.(SuccessResponse); !ok {
+		t.Fatalf("expected a SuccessResponse, got %+v", resp)
+	}
+	status := repositories.lastStatus()
+	if status == nil || status.State == nil || *status.State != "failure" {
+		t.Fatalf("expected a failure review/peer status, got %+v", status)
+	}
+	if status.Description == nil || !strings.Contains(*status.Description, "alice") {
+		t.Errorf("status description %q doesn't name the reviewer", status.GetDescription())
+	}
+}
+
+func TestHandlePullRequestReviewDismissedRevokesOnlyThatReviewer(t *testing.T) {
+	approvals := newApprovalTracker()
+	repo := Repository{Owner: "o", Name: "r"}
+	approvals.recordApproval(repo, 1, "headsha", "alice")
+	approvals.recordApproval(repo, 1, "headsha", "bob")
+
+	body := []byte(`{
+		"action": "dismissed",
+		"review": {"state": "dismissed", "user": {"login": "alice"}},
+		"pull_request": {"number": 1, "head": {"sha": "headsha"}},
+		"repository": {"name": "r", "owner": {"login": "o"}}
+	}`)
+	repositories := &fakeRepositories{}
+	conf := Config{RequiredApprovals: 2}
+
+	resp := handlePullRequestReview(context.Background(), body, conf, approvals, repositories)
+	if _, ok := resp.(SuccessResponse); !ok {
+		t.Fatalf("expected a SuccessResponse, got %+v", resp)
+	}
+	status := repositories.lastStatus()
+	if status == nil || status.Description == nil || *status.Description != "1/2 approvals" {
+		t.Fatalf("expected bob's approval to still count, got %+v", status)
+	}
+}
+
+func TestHandlePullRequestReviewUnknownStateIsIgnored(t *testing.T) {
+	body := []byte(`{
+		"action": "submitted",
+		"review": {"state": "commented", "user": {"login": "alice"}},
+		"pull_request": {"number": 1, "head": {"sha": "headsha"}},
+		"repository": {"name": "r", "owner": {"login": "o"}}
+	}`)
+	approvals := newApprovalTracker()
+	repositories := &fakeRepositories{}
+
+	resp := handlePullRequestReview(context.Background(), body, Config{}, approvals, repositories)
+	if _, ok := resp.(SuccessResponse); !ok {
+		t.Fatalf("expected a SuccessResponse, got %+v", resp)
+	}
+	if len(repositories.statuses) != 0 {
+		t.Fatalf("expected no status to be posted for an unrecognized review state, got %+v", repositories.statuses)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// AppAuth holds the credentials needed to authenticate as a GitHub App and
+// mint short-lived installation access tokens, as opposed to a single
+// user's OAuth access token.
+type AppAuth struct {
+	AppID          int64
+	PrivateKeyPath string
+	// InstallationID overrides the installation id resolved from a
+	// webhook payload. Mainly useful for local testing against a single
+	// installation.
+	InstallationID int64
+}
+
+type Config struct {
+	// AccessToken is used to authenticate with GitHub when no AppAuth is
+	// configured. Deprecated in favor of AppAuth, but still supported
+	// for single-org/single-repo setups.
+	AccessToken string
+	AppAuth     *AppAuth
+	Secret      string
+	Port        int
+
+	// MergeMethod is passed to the GitHub merge API when handling
+	// !merge: one of "merge", "squash" or "rebase".
+	MergeMethod string
+	// AllowUnstable lets !merge proceed when the PR's mergeable_state is
+	// "unstable" (non-required checks failing) instead of treating it
+	// as a failure.
+	AllowUnstable bool
+	// DeleteHeadBranchOnMerge deletes the PR's head branch once !merge
+	// succeeds.
+	DeleteHeadBranchOnMerge bool
+
+	// RequireSignedCommits refuses !squash if any commit on the PR
+	// isn't GPG-verified.
+	RequireSignedCommits bool
+	// SigningKeyPath and SigningKeyPassphrase are used to re-sign the
+	// squashed commit produced by !squash. Re-signing is skipped if
+	// SigningKeyPath is empty.
+	SigningKeyPath       string
+	SigningKeyPassphrase string
+
+	// AllowLegacySHA1 accepts the legacy X-Hub-Signature (HMAC-SHA1)
+	// header when a delivery doesn't include X-Hub-Signature-256.
+	AllowLegacySHA1 bool
+	// RequireSHA256 rejects any delivery that doesn't include
+	// X-Hub-Signature-256, even if AllowLegacySHA1 is set.
+	RequireSHA256 bool
+
+	// RequiredApprovals is how many distinct logins must approve a PR
+	// (via +1 comments or pull_request_review approvals) before
+	// review/peer flips to success.
+	RequiredApprovals int
+}
+
+func NewConfig() Config {
+	conf := Config{
+		AccessToken:             os.Getenv("GITHUB_ACCESS_TOKEN"),
+		Secret:                  mustGetenv("GITHUB_WEBHOOK_SECRET"),
+		Port:                    getenvIntOrDefault("PORT", 8080),
+		MergeMethod:             getenvOrDefault("MERGE_METHOD", "merge"),
+		AllowUnstable:           getenvBoolOrDefault("ALLOW_UNSTABLE_MERGE", false),
+		DeleteHeadBranchOnMerge: getenvBoolOrDefault("DELETE_HEAD_BRANCH_ON_MERGE", false),
+		RequireSignedCommits:    getenvBoolOrDefault("REQUIRE_SIGNED_COMMITS", false),
+		SigningKeyPath:          os.Getenv("SIGNING_KEY_PATH"),
+		SigningKeyPassphrase:    os.Getenv("SIGNING_KEY_PASSPHRASE"),
+		AllowLegacySHA1:         getenvBoolOrDefault("ALLOW_LEGACY_SHA1", false),
+		RequireSHA256:           getenvBoolOrDefault("REQUIRE_SHA256", false),
+		RequiredApprovals:       getenvIntOrDefault("REQUIRED_APPROVALS", 1),
+	}
+	if appIDStr := os.Getenv("GITHUB_APP_ID"); appIDStr != "" {
+		appID, err := strconv.ParseInt(appIDStr, 10, 64)
+		if err != nil {
+			panic(fmt.Sprintf("GITHUB_APP_ID must be an integer: %s", err))
+		}
+		conf.AppAuth = &AppAuth{
+			AppID:          appID,
+			PrivateKeyPath: mustGetenv("GITHUB_APP_PRIVATE_KEY_PATH"),
+		}
+		if installationIDStr := os.Getenv("GITHUB_APP_INSTALLATION_ID"); installationIDStr != "" {
+			installationID, err := strconv.ParseInt(installationIDStr, 10, 64)
+			if err != nil {
+				panic(fmt.Sprintf("GITHUB_APP_INSTALLATION_ID must be an integer: %s", err))
+			}
+			conf.AppAuth.InstallationID = installationID
+		}
+	}
+	return conf
+}
+
+func mustGetenv(name string) string {
+	value := os.Getenv(name)
+	if value == "" {
+		panic(fmt.Sprintf("Please set the %s environment variable", name))
+	}
+	return value
+}
+
+func getenvIntOrDefault(name string, def int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		panic(fmt.Sprintf("%s must be an integer: %s", name, err))
+	}
+	return parsed
+}
+
+func getenvOrDefault(name, def string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return def
+}
+
+func getenvBoolOrDefault(name string, def bool) bool {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		panic(fmt.Sprintf("%s must be a boolean: %s", name, err))
+	}
+	return parsed
+}
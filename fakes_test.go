@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+
+	"github.com/google/go-github/github"
+)
+
+// fakePullRequests is a minimal, in-memory PullRequests used by tests that
+// don't need a real GitHub API.
+type fakePullRequests struct {
+	pr       *github.PullRequest
+	commits  []*github.RepositoryCommit
+	getErr   error
+	getCalls int
+	// prSequence, when set, returns a different PR on each successive
+	// Get call (in order), to exercise resolveMergeableState's polling
+	// without sleeping through the full backoff.
+	prSequence []*github.PullRequest
+}
+
+func (f *fakePullRequests) Get(ctx context.Context, owner, repo string, number int) (*github.PullRequest, *github.Response, error) {
+	f.getCalls++
+	if f.getErr != nil {
+		return nil, nil, f.getErr
+	}
+	if len(f.prSequence) > 0 {
+		idx := f.getCalls - 1
+		if idx >= len(f.prSequence) {
+			idx = len(f.prSequence) - 1
+		}
+		return f.prSequence[idx], nil, nil
+	}
+	return f.pr, nil, nil
+}
+
+func (f *fakePullRequests) ListCommits(ctx context.Context, owner, repo string, number int, opt *github.ListOptions) ([]*github.RepositoryCommit, *github.Response, error) {
+	return f.commits, nil, nil
+}
+
+// fakeRepositories is a minimal, in-memory Repositories used by tests that
+// don't need a real GitHub API. Every CreateStatus call is recorded so
+// tests can assert on the final review/* status that was posted.
+type fakeRepositories struct {
+	combined     *github.CombinedStatus
+	combinedErr  error
+	commits      map[string]*github.RepositoryCommit
+	getCommitErr error
+	statuses     []*github.RepoStatus
+}
+
+func (f *fakeRepositories) CreateStatus(ctx context.Context, owner, repo, ref string, status *github.RepoStatus) (*github.RepoStatus, *github.Response, error) {
+	f.statuses = append(f.statuses, status)
+	return status, nil, nil
+}
+
+func (f *fakeRepositories) GetCombinedStatus(ctx context.Context, owner, repo, ref string, opt *github.ListOptions) (*github.CombinedStatus, *github.Response, error) {
+	return f.combined, nil, f.combinedErr
+}
+
+func (f *fakeRepositories) GetCommit(ctx context.Context, owner, repo, sha string) (*github.RepositoryCommit, *github.Response, error) {
+	if f.getCommitErr != nil {
+		return nil, nil, f.getCommitErr
+	}
+	return f.commits[sha], nil, nil
+}
+
+func (f *fakeRepositories) lastStatus() *github.RepoStatus {
+	if len(f.statuses) == 0 {
+		return nil
+	}
+	return f.statuses[len(f.statuses)-1]
+}
+
+// fakeMerger is a minimal, in-memory Merger used by tests that don't need
+// a real GitHub API.
+type fakeMerger struct {
+	result  *github.PullRequestMergeResult
+	err     error
+	calls   int
+	mergeCh chan struct{}
+}
+
+func (f *fakeMerger) Merge(ctx context.Context, owner, repo string, number int, commitMessage string, options *github.PullRequestOptions) (*github.PullRequestMergeResult, *github.Response, error) {
+	f.calls++
+	if f.mergeCh != nil {
+		close(f.mergeCh)
+	}
+	if f.err != nil {
+		return nil, nil, f.err
+	}
+	return f.result, nil, nil
+}
+
+// fakeReferences is a minimal, in-memory References used by tests that
+// don't need a real GitHub API.
+type fakeReferences struct {
+	deleted []string
+}
+
+func (f *fakeReferences) DeleteRef(ctx context.Context, owner, repo, ref string) (*github.Response, error) {
+	f.deleted = append(f.deleted, ref)
+	return nil, nil
+}
+
+// fakeGit is a minimal, in-memory Git used by handler tests that don't need
+// a real clone on disk.
+type fakeGit struct {
+	repo     Repo
+	getErr   error
+	getCalls int
+}
+
+func (f *fakeGit) GetUpdatedRepo(repoURL, owner, name string) (Repo, error) {
+	f.getCalls++
+	if f.getErr != nil {
+		return nil, f.getErr
+	}
+	return f.repo, nil
+}
+
+// fakeRepo is a minimal, in-memory Repo used by handler tests that don't
+// need to shell out to git.
+type fakeRepo struct {
+	rebaseAutosquashErr error
+	rebaseResult        RebaseResult
+	rebaseErr           error
+	forcePushErr        error
+	headSHA             string
+	headSHAErr          error
+	forcePushedTo       []string
+	signAmendErr        error
+	signAmendCalls      int
+}
+
+func (f *fakeRepo) RebaseAutosquash(baseSHA, headSHA string) error {
+	return f.rebaseAutosquashErr
+}
+
+func (f *fakeRepo) Rebase(base, head string) (RebaseResult, error) {
+	return f.rebaseResult, f.rebaseErr
+}
+
+func (f *fakeRepo) ForcePushHeadTo(ref string) error {
+	f.forcePushedTo = append(f.forcePushedTo, ref)
+	return f.forcePushErr
+}
+
+func (f *fakeRepo) GetHeadSHA() (string, error) {
+	return f.headSHA, f.headSHAErr
+}
+
+func (f *fakeRepo) SignAmend(keyPath, passphrase string) error {
+	f.signAmendCalls++
+	return f.signAmendErr
+}
+
+// fakeGithubClients is a minimal, in-memory GithubClients used by tests
+// that don't need real per-installation token minting. Every Client call
+// is recorded so tests can assert which installation a handler resolved.
+type fakeGithubClients struct {
+	client       *github.Client
+	clientErr    error
+	requestedIDs []int64
+	invalidated  []int64
+}
+
+func (f *fakeGithubClients) Client(installationID int64) (*github.Client, error) {
+	f.requestedIDs = append(f.requestedIDs, installationID)
+	if f.clientErr != nil {
+		return nil, f.clientErr
+	}
+	return f.client, nil
+}
+
+func (f *fakeGithubClients) Invalidate(installationID int64) {
+	f.invalidated = append(f.invalidated, installationID)
+}
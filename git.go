@@ -0,0 +1,245 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Git gives access to a local, up-to-date clone of a remote repository.
+type Git interface {
+	GetUpdatedRepo(repoURL, owner, name string) (Repo, error)
+}
+
+// Repo is a local git working copy that the handlers can run commands
+// against.
+type Repo interface {
+	RebaseAutosquash(baseSHA, headSHA string) error
+	// Rebase replays head onto the latest base, leaving the working
+	// copy on a clean checkout of head again (rebase aborted) if it
+	// conflicts.
+	Rebase(base, head string) (RebaseResult, error)
+	ForcePushHeadTo(ref string) error
+	GetHeadSHA() (string, error)
+	// SignAmend re-signs HEAD with the GPG key at keyPath, leaving its
+	// tree and message untouched. Used to re-sign the commit produced
+	// by an interactive rebase, which git doesn't sign on its own.
+	SignAmend(keyPath, passphrase string) error
+}
+
+// RebaseConflict names what failed during a rebase: the files left
+// conflicted and the commit being applied when it happened.
+type RebaseConflict struct {
+	Files []string
+	SHA   string
+}
+
+// RebaseResult is the outcome of a Repo.Rebase call. Conflict is nil when
+// the rebase completed cleanly.
+type RebaseResult struct {
+	Conflict *RebaseConflict
+}
+
+// RebaseConflictError is returned by RebaseAutosquash when the autosquash
+// rebase stops on a conflict, as opposed to failing for some other reason.
+type RebaseConflictError struct {
+	Conflict RebaseConflict
+}
+
+func (e *RebaseConflictError) Error() string {
+	return fmt.Sprintf("rebase conflict in %s while applying %s", strings.Join(e.Conflict.Files, ", "), e.Conflict.SHA)
+}
+
+type git struct {
+	ReposDir string
+}
+
+func NewGit(reposDir string) Git {
+	return &git{reposDir}
+}
+
+func (g *git) GetUpdatedRepo(repoURL, owner, name string) (Repo, error) {
+	dir := filepath.Join(g.ReposDir, owner, name)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+			return nil, err
+		}
+		if err := runGit("", "clone", repoURL, dir); err != nil {
+			return nil, err
+		}
+	} else if err := runGit(dir, "fetch", "origin"); err != nil {
+		return nil, err
+	}
+	return &repo{Dir: dir}, nil
+}
+
+type repo struct {
+	Dir string
+}
+
+func (r *repo) RebaseAutosquash(baseSHA, headSHA string) error {
+	if err := runGit(r.Dir, "checkout", "-f", headSHA); err != nil {
+		return err
+	}
+	cmd := exec.Command("git", "rebase", "-i", "--autosquash", baseSHA)
+	cmd.Dir = r.Dir
+	cmd.Env = append(os.Environ(), "GIT_SEQUENCE_EDITOR=:")
+	out, err := cmd.CombinedOutput()
+	if err == nil {
+		return nil
+	}
+	if conflict, ok := r.parseRebaseConflict(out); ok {
+		if abortErr := r.abortRebase(); abortErr != nil {
+			return fmt.Errorf("hit a conflict and failed to abort the rebase, leaving the repo dirty: %s", abortErr)
+		}
+		return &RebaseConflictError{Conflict: conflict}
+	}
+	return fmt.Errorf("git rebase --autosquash failed: %s: %s", err, out)
+}
+
+func (r *repo) Rebase(base, head string) (RebaseResult, error) {
+	if err := runGit(r.Dir, "checkout", "-f", head); err != nil {
+		return RebaseResult{}, err
+	}
+	cmd := exec.Command("git", "rebase", "origin/"+base)
+	cmd.Dir = r.Dir
+	out, err := cmd.CombinedOutput()
+	if err == nil {
+		return RebaseResult{}, nil
+	}
+	if conflict, ok := r.parseRebaseConflict(out); ok {
+		if abortErr := r.abortRebase(); abortErr != nil {
+			return RebaseResult{}, fmt.Errorf("hit a conflict and failed to abort the rebase, leaving the repo dirty: %s", abortErr)
+		}
+		return RebaseResult{Conflict: &conflict}, nil
+	}
+	return RebaseResult{}, fmt.Errorf("git rebase failed: %s: %s", err, out)
+}
+
+func (r *repo) abortRebase() error {
+	return runGit(r.Dir, "rebase", "--abort")
+}
+
+// conflictMarkers matches the two shapes of git's "CONFLICT" lines we
+// care about, so the conflicting paths can be reported back:
+//   - "CONFLICT (content): Merge conflict in <path>"
+//   - "CONFLICT (modify/delete): <path> deleted in ..."
+var conflictMarkers = []*regexp.Regexp{
+	regexp.MustCompile(`^CONFLICT \([^)]+\): Merge conflict in (.+)$`),
+	regexp.MustCompile(`^CONFLICT \(modify/delete\): (\S+) deleted in`),
+}
+
+func (r *repo) parseRebaseConflict(out []byte) (RebaseConflict, bool) {
+	if !strings.Contains(string(out), "CONFLICT") {
+		return RebaseConflict{}, false
+	}
+	var files []string
+	for _, line := range strings.Split(string(out), "\n") {
+		for _, marker := range conflictMarkers {
+			if matches := marker.FindStringSubmatch(line); matches != nil {
+				files = append(files, matches[1])
+				break
+			}
+		}
+	}
+	sha := ""
+	cmd := exec.Command("git", "rev-parse", "REBASE_HEAD")
+	cmd.Dir = r.Dir
+	if shaOut, err := cmd.Output(); err == nil {
+		sha = strings.TrimSpace(string(shaOut))
+	}
+	return RebaseConflict{Files: files, SHA: sha}, true
+}
+
+func (r *repo) ForcePushHeadTo(ref string) error {
+	return runGit(r.Dir, "push", "--force", "origin", "HEAD:"+ref)
+}
+
+var gpgImportedKeyID = regexp.MustCompile(`key ([0-9A-F]+):`)
+
+func (r *repo) SignAmend(keyPath, passphrase string) error {
+	keyID, err := importGPGKey(keyPath)
+	if err != nil {
+		return fmt.Errorf("failed to import the signing key: %s", err)
+	}
+	gpgProgram, cleanup, err := loopbackGPGProgram(passphrase)
+	if err != nil {
+		return fmt.Errorf("failed to prepare a non-interactive gpg wrapper: %s", err)
+	}
+	defer cleanup()
+	cmd := exec.Command("git", "-c", "user.signingkey="+keyID, "-c", "gpg.program="+gpgProgram, "commit", "--amend", "--no-edit", "-S")
+	cmd.Dir = r.Dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git commit --amend -S failed: %s: %s", err, out)
+	}
+	return nil
+}
+
+// loopbackGPGProgram writes a small wrapper script that invokes gpg with
+// --pinentry-mode loopback and the passphrase read from a 0600 file, and
+// returns its path for use as git's gpg.program. Neither git nor gpg reads
+// a passphrase from the environment, and git execs gpg.program as a single
+// literal path rather than a shell command line, so extra gpg flags can't
+// just be appended to the config value; a wrapper script is the only way
+// to get a passphrase-protected key to sign non-interactively. The caller
+// must invoke the returned cleanup func once the commit has been signed.
+func loopbackGPGProgram(passphrase string) (program string, cleanup func(), err error) {
+	dir, err := ioutil.TempDir("", "github-review-helper-gpg")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup = func() { os.RemoveAll(dir) }
+	passphraseFile := filepath.Join(dir, "passphrase")
+	if err := ioutil.WriteFile(passphraseFile, []byte(passphrase), 0600); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	script := filepath.Join(dir, "gpg-wrapper.sh")
+	contents := fmt.Sprintf("#!/bin/sh\nexec gpg --batch --yes --pinentry-mode loopback --passphrase-file %s \"$@\"\n", passphraseFile)
+	if err := ioutil.WriteFile(script, []byte(contents), 0700); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return script, cleanup, nil
+}
+
+// importGPGKey imports the (possibly passphrase-protected) secret key at
+// keyPath. gpg --import never needs the passphrase: it stores the key
+// material as-is without decrypting it, and only prompts for the
+// passphrase later, when the key is actually used to sign (handled by
+// loopbackGPGProgram).
+func importGPGKey(keyPath string) (string, error) {
+	cmd := exec.Command("gpg", "--batch", "--yes", "--import", keyPath)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("gpg --import failed: %s: %s", err, out)
+	}
+	matches := gpgImportedKeyID.FindSubmatch(out)
+	if matches == nil {
+		return "", fmt.Errorf("couldn't determine the imported key id from gpg's output: %s", out)
+	}
+	return string(matches[1]), nil
+}
+
+func (r *repo) GetHeadSHA() (string, error) {
+	cmd := exec.Command("git", "rev-parse", "HEAD")
+	cmd.Dir = r.Dir
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func runGit(dir string, args ...string) error {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git %s failed: %s: %s", strings.Join(args, " "), err, out)
+	}
+	return nil
+}
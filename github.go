@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/google/go-github/github"
+)
+
+// PullRequests is the subset of github.PullRequestsService that we depend
+// on, so that it can be faked out in tests.
+type PullRequests interface {
+	Get(ctx context.Context, owner, repo string, number int) (*github.PullRequest, *github.Response, error)
+	ListCommits(ctx context.Context, owner, repo string, number int, opt *github.ListOptions) ([]*github.RepositoryCommit, *github.Response, error)
+}
+
+// Repositories is the subset of github.RepositoriesService that we depend
+// on, so that it can be faked out in tests.
+type Repositories interface {
+	CreateStatus(ctx context.Context, owner, repo, ref string, status *github.RepoStatus) (*github.RepoStatus, *github.Response, error)
+	GetCombinedStatus(ctx context.Context, owner, repo, ref string, opt *github.ListOptions) (*github.CombinedStatus, *github.Response, error)
+	GetCommit(ctx context.Context, owner, repo, sha string) (*github.RepositoryCommit, *github.Response, error)
+}
+
+// Merger is the subset of github.PullRequestsService that lets a PR be
+// merged, kept separate from PullRequests since not every handler needs
+// merge access.
+type Merger interface {
+	Merge(ctx context.Context, owner, repo string, number int, commitMessage string, options *github.PullRequestOptions) (*github.PullRequestMergeResult, *github.Response, error)
+}
+
+// References is the subset of github.GitService that we depend on, so
+// that it can be faked out in tests.
+type References interface {
+	DeleteRef(ctx context.Context, owner, repo, ref string) (*github.Response, error)
+}
+
+// Handler adapts a function taking a Response-returning signature to
+// http.Handler, so that handler functions can return instead of writing to
+// the ResponseWriter directly.
+type Handler func(w http.ResponseWriter, r *http.Request) Response
+
+func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h(w, r).WriteTo(w)
+}
+
+// Response is returned by a Handler and knows how to write itself to the
+// ResponseWriter.
+type Response interface {
+	WriteTo(w http.ResponseWriter)
+}
+
+type SuccessResponse struct {
+	Message string
+}
+
+func (s SuccessResponse) WriteTo(w http.ResponseWriter) {
+	message := s.Message
+	if message == "" {
+		message = "Success"
+	}
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, message)
+}
+
+type ErrorResponse struct {
+	Err     error
+	Code    int
+	Message string
+}
+
+func (e ErrorResponse) WriteTo(w http.ResponseWriter) {
+	log.Printf("%s: %s\n", e.Message, e.Err)
+	http.Error(w, e.Message, e.Code)
+}
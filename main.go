@@ -1,9 +1,7 @@
 package main
 
 import (
-	"crypto/hmac"
-	"crypto/sha1"
-	"encoding/hex"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -36,10 +34,11 @@ type (
 	}
 
 	IssueComment struct {
-		IssueNumber   int
-		Comment       string
-		IsPullRequest bool
-		Repository    Repository
+		IssueNumber    int
+		Comment        string
+		CommenterLogin string
+		IsPullRequest  bool
+		Repository     Repository
 	}
 
 	PullRequestEvent struct {
@@ -71,7 +70,10 @@ func (p PullRequestEvent) Issue() Issue {
 
 func main() {
 	conf := NewConfig()
-	githubClient := initGithubClient(conf.AccessToken)
+	githubClients, err := newGithubClients(conf)
+	if err != nil {
+		panic(err)
+	}
 	reposDir, err := ioutil.TempDir("", "github-review-helper")
 	if err != nil {
 		panic(err)
@@ -79,44 +81,78 @@ func main() {
 	defer os.RemoveAll(reposDir)
 
 	git := NewGit(reposDir)
+	approvals := newApprovalTracker()
 
 	mux := http.NewServeMux()
-	mux.Handle("/", CreateHandler(conf, git, githubClient.PullRequests, githubClient.Repositories))
+	mux.Handle("/", CreateHandler(conf, git, githubClients, approvals))
 
 	graceful.Run(fmt.Sprintf(":%d", conf.Port), 10*time.Second, mux)
 }
 
-func CreateHandler(conf Config, git Git, pullRequests PullRequests, repositories Repositories) Handler {
+// newGithubClients builds the GithubClients implementation appropriate for
+// the configured authentication method: a GitHub App minting per-
+// installation tokens, or a single shared access token.
+func newGithubClients(conf Config) (GithubClients, error) {
+	if conf.AppAuth != nil {
+		return NewAppGithubClients(*conf.AppAuth)
+	}
+	return NewStaticGithubClients(conf.AccessToken), nil
+}
+
+func CreateHandler(conf Config, git Git, githubClients GithubClients, approvals *approvalTracker) Handler {
 	return func(w http.ResponseWriter, r *http.Request) Response {
 		body, err := ioutil.ReadAll(r.Body)
 		if err != nil {
 			return ErrorResponse{err, http.StatusInternalServerError, "Failed to read the request's body"}
 		}
-		signature := r.Header.Get("X-Hub-Signature")
-		if signature == "" {
-			return ErrorResponse{nil, http.StatusUnauthorized, "Please provide a X-Hub-Signature"}
-		}
-		hasSecret, err := hasSecret(body, signature, conf.Secret)
-		if err != nil {
-			return ErrorResponse{err, http.StatusInternalServerError, "Failed to check the signature"}
-		} else if !hasSecret {
-			return ErrorResponse{nil, http.StatusForbidden, "Bad X-Hub-Signature"}
+		if errResp := verifyWebhookSignature(body, r.Header, conf); errResp != nil {
+			return errResp
 		}
+		ctx := r.Context()
 		eventType := r.Header.Get("X-Github-Event")
 		switch eventType {
+		case "installation":
+			return handleInstallationEvent(body, githubClients)
+		case "installation_repositories":
+			return handleInstallationRepositoriesEvent(body, githubClients)
 		case "issue_comment":
-			return handleIssueComment(w, body, git, pullRequests, repositories)
+			return handleIssueComment(ctx, w, body, conf, git, githubClients, approvals)
 		case "pull_request":
-			return handlePullRequest(w, body, pullRequests, repositories)
+			return handlePullRequest(ctx, w, body, conf, githubClients)
+		case "pull_request_review":
+			client, errResp := resolveClient(body, conf, githubClients)
+			if errResp != nil {
+				return errResp
+			}
+			return handlePullRequestReview(ctx, body, conf, approvals, client.Repositories)
 		}
 		return SuccessResponse{"Not an event I understand. Ignoring."}
 	}
 }
 
+// resolveClient figures out which installation a webhook event belongs to
+// and returns the *github.Client to use for it. A configured
+// AppAuth.InstallationID always takes precedence, which is mainly useful
+// when running against a single installation during local testing.
+func resolveClient(body []byte, conf Config, githubClients GithubClients) (*github.Client, *ErrorResponse) {
+	installationID, err := parseInstallationID(body)
+	if err != nil {
+		return nil, &ErrorResponse{err, http.StatusInternalServerError, "Failed to parse the installation id"}
+	}
+	if conf.AppAuth != nil && conf.AppAuth.InstallationID != 0 {
+		installationID = conf.AppAuth.InstallationID
+	}
+	client, err := githubClients.Client(installationID)
+	if err != nil {
+		return nil, &ErrorResponse{err, http.StatusBadGateway, "Failed to get a GitHub client for this installation"}
+	}
+	return client, nil
+}
+
 // startsWithPlusOne matches strings that start with either a +1 (not followed by other digits) or a :+1: emoji
 var startsWithPlusOne = regexp.MustCompile(`^(:\+1:|\+1($|\D))`)
 
-func handleIssueComment(w http.ResponseWriter, body []byte, git Git, pullRequests PullRequests, repositories Repositories) Response {
+func handleIssueComment(ctx context.Context, w http.ResponseWriter, body []byte, conf Config, git Git, githubClients GithubClients, approvals *approvalTracker) Response {
 	issueComment, err := parseIssueComment(body)
 	if err != nil {
 		return ErrorResponse{err, http.StatusInternalServerError, "Failed to parse the request's body"}
@@ -124,20 +160,41 @@ func handleIssueComment(w http.ResponseWriter, body []byte, git Git, pullRequest
 	if !issueComment.IsPullRequest {
 		return SuccessResponse{"Not a PR. Ignoring."}
 	}
+	client, errResp := resolveClient(body, conf, githubClients)
+	if errResp != nil {
+		return errResp
+	}
 	switch {
 	case issueComment.Comment == "!squash":
-		return handleSquash(w, issueComment, git, pullRequests, repositories)
+		return handleSquash(ctx, w, issueComment, conf, git, client.PullRequests, client.Repositories)
+	case issueComment.Comment == "!merge":
+		return handleMerge(ctx, issueComment, conf, client.PullRequests, client.PullRequests, client.Repositories, client.Git)
+	case issueComment.Comment == "!rebase":
+		return handleRebase(ctx, issueComment, git, client.PullRequests, client.Repositories)
 	case startsWithPlusOne.MatchString(issueComment.Comment):
-		return handlePlusOne(w, issueComment, pullRequests, repositories)
+		return handlePlusOne(ctx, w, issueComment, conf, approvals, client.PullRequests, client.Repositories)
 	}
 	return SuccessResponse{"Not a command I understand. Ignoring."}
 }
 
-func handleSquash(w http.ResponseWriter, issueComment IssueComment, git Git, pullRequests PullRequests, repositories Repositories) Response {
-	pr, errResp := getPR(issueComment, pullRequests)
+func handleSquash(ctx context.Context, w http.ResponseWriter, issueComment IssueComment, conf Config, git Git, pullRequests PullRequests, repositories Repositories) Response {
+	pr, errResp := getPR(ctx, issueComment, pullRequests)
 	if errResp != nil {
 		return errResp
 	}
+	if conf.RequireSignedCommits {
+		commits, errResp := getCommits(ctx, issueComment, pullRequests)
+		if errResp != nil {
+			return errResp
+		}
+		verifications, errResp := verifyCommits(ctx, issueComment.Repository, commits, repositories)
+		if errResp != nil {
+			return errResp
+		}
+		if resp := checkSignedCommits(ctx, issueComment.Repository, *pr.Head.SHA, verifications, repositories); resp != nil {
+			return resp
+		}
+	}
 	log.Printf("Squashing %s that's going to be merged into %s\n", *pr.Head.Ref, *pr.Base.Ref)
 	repo, err := git.GetUpdatedRepo(issueComment.Repository.URL, issueComment.Repository.Owner, issueComment.Repository.Name)
 	if err != nil {
@@ -145,8 +202,12 @@ func handleSquash(w http.ResponseWriter, issueComment IssueComment, git Git, pul
 	}
 	if err = repo.RebaseAutosquash(*pr.Base.SHA, *pr.Head.SHA); err != nil {
 		log.Printf("Failed to autosquash the commits with an interactive rebase: %s. Setting a failure status.\n", err)
-		status := createSquashStatus("failure", "Failed to automatically squash the fixup! and squash! commits. Please squash manually")
-		if errResp := setStatus(issueComment.Repository, *pr.Head.SHA, status, repositories); errResp != nil {
+		message := "Failed to automatically squash the fixup! and squash! commits. Please squash manually"
+		if conflictErr, ok := err.(*RebaseConflictError); ok {
+			message = conflictMessage(conflictErr.Conflict)
+		}
+		status := createSquashStatus("failure", message)
+		if errResp := setStatus(ctx, issueComment.Repository, *pr.Head.SHA, status, repositories); errResp != nil {
 			return errResp
 		}
 		return SuccessResponse{"Failed to autosquash the commits with an interactive rebase. Reported the failure."}
@@ -154,27 +215,39 @@ func handleSquash(w http.ResponseWriter, issueComment IssueComment, git Git, pul
 	if err = repo.ForcePushHeadTo(*pr.Head.Ref); err != nil {
 		return ErrorResponse{err, http.StatusInternalServerError, "Failed to push the squashed version"}
 	}
+	if conf.SigningKeyPath != "" {
+		if err = repo.SignAmend(conf.SigningKeyPath, conf.SigningKeyPassphrase); err != nil {
+			return ErrorResponse{err, http.StatusInternalServerError, "Failed to re-sign the squashed commit"}
+		}
+		if err = repo.ForcePushHeadTo(*pr.Head.Ref); err != nil {
+			return ErrorResponse{err, http.StatusInternalServerError, "Failed to push the signed squashed version"}
+		}
+	}
 	squashedHeadSHA, err := repo.GetHeadSHA()
 	if err != nil {
 		return ErrorResponse{err, http.StatusInternalServerError, "Failed to get the squashed branch's HEAD's SHA"}
 	}
 	status := createSquashStatus("success", "All fixup! and squash! commits successfully squashed")
-	if errResp := setStatus(issueComment.Repository, squashedHeadSHA, status, repositories); errResp != nil {
+	if errResp := setStatus(ctx, issueComment.Repository, squashedHeadSHA, status, repositories); errResp != nil {
 		return errResp
 	}
 	return SuccessResponse{}
 }
 
-func handlePlusOne(w http.ResponseWriter, issueComment IssueComment, pullRequests PullRequests, repositories Repositories) Response {
-	log.Printf("Marking PR %s as peer reviewed\n", issueComment.Issue().FullName())
-	status := createPeerReviewStatus("success", "This PR has been peer reviewed")
-	if errResp := setPRHeadStatus(issueComment, status, pullRequests, repositories); errResp != nil {
+func handlePlusOne(ctx context.Context, w http.ResponseWriter, issueComment IssueComment, conf Config, approvals *approvalTracker, pullRequests PullRequests, repositories Repositories) Response {
+	pr, errResp := getPR(ctx, issueComment, pullRequests)
+	if errResp != nil {
+		return errResp
+	}
+	log.Printf("Recording a peer review approval for PR %s from %s\n", issueComment.Issue().FullName(), issueComment.CommenterLogin)
+	status := approvalStatus(approvals, issueComment.Repository, issueComment.IssueNumber, *pr.Head.SHA, issueComment.CommenterLogin, conf.RequiredApprovals)
+	if errResp := setStatus(ctx, issueComment.Repository, *pr.Head.SHA, status, repositories); errResp != nil {
 		return errResp
 	}
 	return SuccessResponse{}
 }
 
-func handlePullRequest(w http.ResponseWriter, body []byte, pullRequests PullRequests, repositories Repositories) Response {
+func handlePullRequest(ctx context.Context, w http.ResponseWriter, body []byte, conf Config, githubClients GithubClients) Response {
 	pullRequestEvent, err := parsePullRequestEvent(body)
 	if err != nil {
 		return ErrorResponse{err, http.StatusInternalServerError, "Failed to parse the request's body"}
@@ -182,22 +255,69 @@ func handlePullRequest(w http.ResponseWriter, body []byte, pullRequests PullRequ
 	if !(pullRequestEvent.Action == "opened" || pullRequestEvent.Action == "synchronize") {
 		return SuccessResponse{"PR not opened or synchronized. Ignoring."}
 	}
+	client, errResp := resolveClient(body, conf, githubClients)
+	if errResp != nil {
+		return errResp
+	}
 	log.Printf("Checking for fixup commits for PR %s.\n", pullRequestEvent.Issue().FullName())
-	commits, errResp := getCommits(pullRequestEvent, pullRequests)
+	commits, errResp := getCommits(ctx, pullRequestEvent, client.PullRequests)
 	if errResp != nil {
 		return errResp
 	}
+	if pullRequestEvent.Action == "synchronize" {
+		verifications, errResp := verifyCommits(ctx, pullRequestEvent.Repository, commits, client.Repositories)
+		if errResp != nil {
+			return errResp
+		}
+		if errResp := setPRHeadStatus(ctx, pullRequestEvent, signingStatusFor(verifications), client.PullRequests, client.Repositories); errResp != nil {
+			return errResp
+		}
+	}
 	if !includesFixupCommits(commits) {
 		return SuccessResponse{}
 	}
 	status := createSquashStatus("pending", "This PR needs to be squashed with !squash before merging")
-	if errResp := setPRHeadStatus(pullRequestEvent, status, pullRequests, repositories); errResp != nil {
+	if errResp := setPRHeadStatus(ctx, pullRequestEvent, status, client.PullRequests, client.Repositories); errResp != nil {
 		return errResp
 	}
 	return SuccessResponse{}
 }
 
-func includesFixupCommits(commits []github.RepositoryCommit) bool {
+// installationEvent covers the shared shape of the "installation" and
+// "installation_repositories" webhook events: which installation they're
+// about and what happened to it.
+type installationEvent struct {
+	Action       string `json:"action"`
+	Installation struct {
+		ID int64 `json:"id"`
+	} `json:"installation"`
+}
+
+func handleInstallationEvent(body []byte, githubClients GithubClients) Response {
+	var event installationEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return ErrorResponse{err, http.StatusInternalServerError, "Failed to parse the installation event"}
+	}
+	log.Printf("Installation %d %s\n", event.Installation.ID, event.Action)
+	if event.Action == "deleted" || event.Action == "suspend" {
+		githubClients.Invalidate(event.Installation.ID)
+	}
+	return SuccessResponse{}
+}
+
+func handleInstallationRepositoriesEvent(body []byte, githubClients GithubClients) Response {
+	var event installationEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return ErrorResponse{err, http.StatusInternalServerError, "Failed to parse the installation_repositories event"}
+	}
+	log.Printf("Installation %d repositories %s\n", event.Installation.ID, event.Action)
+	if event.Action == "removed" {
+		githubClients.Invalidate(event.Installation.ID)
+	}
+	return SuccessResponse{}
+}
+
+func includesFixupCommits(commits []*github.RepositoryCommit) bool {
 	for _, commit := range commits {
 		if strings.HasPrefix(*commit.Commit.Message, "fixup! ") || strings.HasPrefix(*commit.Commit.Message, "squash! ") {
 			return true
@@ -206,17 +326,17 @@ func includesFixupCommits(commits []github.RepositoryCommit) bool {
 	return false
 }
 
-func setPRHeadStatus(issueable Issueable, status *github.RepoStatus, pullRequests PullRequests, repositories Repositories) *ErrorResponse {
-	pr, errResp := getPR(issueable, pullRequests)
+func setPRHeadStatus(ctx context.Context, issueable Issueable, status *github.RepoStatus, pullRequests PullRequests, repositories Repositories) *ErrorResponse {
+	pr, errResp := getPR(ctx, issueable, pullRequests)
 	if errResp != nil {
 		return errResp
 	}
 	repository := issueable.Issue().Repository
-	return setStatus(repository, *pr.Head.SHA, status, repositories)
+	return setStatus(ctx, repository, *pr.Head.SHA, status, repositories)
 }
 
-func setStatus(repository Repository, commitRef string, status *github.RepoStatus, repositories Repositories) *ErrorResponse {
-	_, _, err := repositories.CreateStatus(repository.Owner, repository.Name, commitRef, status)
+func setStatus(ctx context.Context, repository Repository, commitRef string, status *github.RepoStatus, repositories Repositories) *ErrorResponse {
+	_, _, err := repositories.CreateStatus(ctx, repository.Owner, repository.Name, commitRef, status)
 	if err != nil {
 		message := fmt.Sprintf("Failed to create a %s status for commit %s", *status.State, commitRef)
 		return &ErrorResponse{err, http.StatusBadGateway, message}
@@ -224,9 +344,9 @@ func setStatus(repository Repository, commitRef string, status *github.RepoStatu
 	return nil
 }
 
-func getPR(issueable Issueable, pullRequests PullRequests) (*github.PullRequest, *ErrorResponse) {
+func getPR(ctx context.Context, issueable Issueable, pullRequests PullRequests) (*github.PullRequest, *ErrorResponse) {
 	issue := issueable.Issue()
-	pr, _, err := pullRequests.Get(issue.Repository.Owner, issue.Repository.Name, issue.Number)
+	pr, _, err := pullRequests.Get(ctx, issue.Repository.Owner, issue.Repository.Name, issue.Number)
 	if err != nil {
 		message := fmt.Sprintf("Getting PR %s failed", issue.FullName())
 		return nil, &ErrorResponse{err, http.StatusBadGateway, message}
@@ -234,9 +354,9 @@ func getPR(issueable Issueable, pullRequests PullRequests) (*github.PullRequest,
 	return pr, nil
 }
 
-func getCommits(issueable Issueable, pullRequests PullRequests) ([]github.RepositoryCommit, *ErrorResponse) {
+func getCommits(ctx context.Context, issueable Issueable, pullRequests PullRequests) ([]*github.RepositoryCommit, *ErrorResponse) {
 	issue := issueable.Issue()
-	commits, _, err := pullRequests.ListCommits(issue.Repository.Owner, issue.Repository.Name, issue.Number, nil)
+	commits, _, err := pullRequests.ListCommits(ctx, issue.Repository.Owner, issue.Repository.Name, issue.Number, nil)
 	if err != nil {
 		message := fmt.Sprintf("Getting commits for PR %s failed", issue.FullName())
 		return nil, &ErrorResponse{err, http.StatusBadGateway, message}
@@ -285,6 +405,9 @@ func parseIssueComment(body []byte) (IssueComment, error) {
 		} `json:"repository"`
 		Comment struct {
 			Body string `json:"body"`
+			User struct {
+				Login string `json:"login"`
+			} `json:"user"`
 		} `json:"comment"`
 	}
 	err := json.Unmarshal(body, &message)
@@ -292,9 +415,10 @@ func parseIssueComment(body []byte) (IssueComment, error) {
 		return IssueComment{}, err
 	}
 	return IssueComment{
-		IssueNumber:   message.Issue.Number,
-		Comment:       message.Comment.Body,
-		IsPullRequest: message.Issue.PullRequest.URL != "",
+		IssueNumber:    message.Issue.Number,
+		Comment:        message.Comment.Body,
+		CommenterLogin: message.Comment.User.Login,
+		IsPullRequest:  message.Issue.PullRequest.URL != "",
 		Repository: Repository{
 			Owner: message.Repository.Owner.Login,
 			Name:  message.Repository.Name,
@@ -333,18 +457,16 @@ func parsePullRequestEvent(body []byte) (PullRequestEvent, error) {
 	}, nil
 }
 
-func hasSecret(message []byte, signature, key string) (bool, error) {
-	var messageMACString string
-	fmt.Sscanf(signature, "sha1=%s", &messageMACString)
-	messageMAC, err := hex.DecodeString(messageMACString)
-	if err != nil {
-		return false, err
+func parseInstallationID(body []byte) (int64, error) {
+	var message struct {
+		Installation struct {
+			ID int64 `json:"id"`
+		} `json:"installation"`
 	}
-
-	mac := hmac.New(sha1.New, []byte(key))
-	mac.Write(message)
-	expectedMAC := mac.Sum(nil)
-	return hmac.Equal(messageMAC, expectedMAC), nil
+	if err := json.Unmarshal(body, &message); err != nil {
+		return 0, err
+	}
+	return message.Installation.ID, nil
 }
 
 func (i Issue) FullName() string {
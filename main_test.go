@@ -0,0 +1,530 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-github/github"
+)
+
+// signedRequest builds a webhook request carrying a valid
+// X-Hub-Signature-256 for conf.Secret, using the sign helper from
+// signature_test.go.
+func signedRequest(t *testing.T, conf Config, eventType string, body []byte) *http.Request {
+	t.Helper()
+	req := httptest.NewRequest("POST", "/", bytes.NewReader(body))
+	req.Header.Set("X-Github-Event", eventType)
+	req.Header.Set("X-Hub-Signature-256", sign(sha256.New, "sha256=", conf.Secret, body))
+	return req
+}
+
+func TestCreateHandlerRequiresAValidSignature(t *testing.T) {
+	conf := Config{Secret: "s3cr3t"}
+	githubClients := &fakeGithubClients{}
+	handler := CreateHandler(conf, &fakeGit{}, githubClients, newApprovalTracker())
+
+	req := httptest.NewRequest("POST", "/", bytes.NewReader([]byte(`{}`)))
+	req.Header.Set("X-Github-Event", "installation")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+	if len(githubClients.requestedIDs) != 0 || len(githubClients.invalidated) != 0 {
+		t.Fatal("expected routing to be skipped entirely for an unauthenticated request")
+	}
+}
+
+func TestCreateHandlerIgnoresAnUnrecognizedEventType(t *testing.T) {
+	conf := Config{Secret: "s3cr3t"}
+	githubClients := &fakeGithubClients{}
+	handler := CreateHandler(conf, &fakeGit{}, githubClients, newApprovalTracker())
+
+	body := []byte(`{}`)
+	req := signedRequest(t, conf, "fork", body)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if len(githubClients.requestedIDs) != 0 {
+		t.Fatal("expected an unrecognized event type not to resolve a client")
+	}
+}
+
+func TestCreateHandlerRoutesInstallationEvents(t *testing.T) {
+	conf := Config{Secret: "s3cr3t"}
+
+	tests := []struct {
+		name            string
+		action          string
+		wantInvalidated bool
+	}{
+		{name: "deleted invalidates", action: "deleted", wantInvalidated: true},
+		{name: "suspend invalidates", action: "suspend", wantInvalidated: true},
+		{name: "new_permissions_accepted is ignored", action: "new_permissions_accepted"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			githubClients := &fakeGithubClients{}
+			handler := CreateHandler(conf, &fakeGit{}, githubClients, newApprovalTracker())
+			body := []byte(`{"action":"` + tt.action + `","installation":{"id":42}}`)
+			req := signedRequest(t, conf, "installation", body)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if tt.wantInvalidated {
+				if len(githubClients.invalidated) != 1 || githubClients.invalidated[0] != 42 {
+					t.Fatalf("invalidated = %v, want [42]", githubClients.invalidated)
+				}
+			} else if len(githubClients.invalidated) != 0 {
+				t.Fatalf("expected no invalidation, got %v", githubClients.invalidated)
+			}
+		})
+	}
+}
+
+func TestCreateHandlerRoutesInstallationRepositoriesEvents(t *testing.T) {
+	conf := Config{Secret: "s3cr3t"}
+
+	tests := []struct {
+		name            string
+		action          string
+		wantInvalidated bool
+	}{
+		{name: "removed invalidates", action: "removed", wantInvalidated: true},
+		{name: "added is ignored", action: "added"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			githubClients := &fakeGithubClients{}
+			handler := CreateHandler(conf, &fakeGit{}, githubClients, newApprovalTracker())
+			body := []byte(`{"action":"` + tt.action + `","installation":{"id":7}}`)
+			req := signedRequest(t, conf, "installation_repositories", body)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if tt.wantInvalidated {
+				if len(githubClients.invalidated) != 1 || githubClients.invalidated[0] != 7 {
+					t.Fatalf("invalidated = %v, want [7]", githubClients.invalidated)
+				}
+			} else if len(githubClients.invalidated) != 0 {
+				t.Fatalf("expected no invalidation, got %v", githubClients.invalidated)
+			}
+		})
+	}
+}
+
+func TestCreateHandlerRoutesIssueCommentToResolveClient(t *testing.T) {
+	conf := Config{Secret: "s3cr3t"}
+	githubClients := &fakeGithubClients{client: github.NewClient(nil)}
+	handler := CreateHandler(conf, &fakeGit{}, githubClients, newApprovalTracker())
+
+	body := []byte(`{
+		"issue": {"Number": 1, "pull_request": {"url": "https://api.github.com/repos/o/r/pulls/1"}},
+		"repository": {"name": "r", "owner": {"login": "o"}},
+		"comment": {"body": "not a command", "user": {"login": "alice"}},
+		"installation": {"id": 99}
+	}`)
+	req := signedRequest(t, conf, "issue_comment", body)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d, body: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if len(githubClients.requestedIDs) != 1 || githubClients.requestedIDs[0] != 99 {
+		t.Fatalf("requestedIDs = %v, want [99]", githubClients.requestedIDs)
+	}
+}
+
+func TestCreateHandlerIgnoresPullRequestEventsBeforeResolvingAClient(t *testing.T) {
+	conf := Config{Secret: "s3cr3t"}
+	githubClients := &fakeGithubClients{}
+	handler := CreateHandler(conf, &fakeGit{}, githubClients, newApprovalTracker())
+
+	body := []byte(`{
+		"action": "closed",
+		"number": 1,
+		"repository": {"name": "r", "owner": {"login": "o"}},
+		"installation": {"id": 99}
+	}`)
+	req := signedRequest(t, conf, "pull_request", body)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d, body: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if len(githubClients.requestedIDs) != 0 {
+		t.Fatal("expected a closed PR action to be ignored before resolving a client")
+	}
+}
+
+func TestCreateHandlerRoutesPullRequestReviewToResolveClient(t *testing.T) {
+	conf := Config{Secret: "s3cr3t"}
+	githubClients := &fakeGithubClients{client: github.NewClient(nil)}
+	handler := CreateHandler(conf, &fakeGit{}, githubClients, newApprovalTracker())
+
+	body := []byte(`{
+		"action": "submitted",
+		"review": {"state": "commented", "user": {"login": "alice"}},
+		"pull_request": {"number": 1, "head": {"sha": "headsha"}},
+		"repository": {"name": "r", "owner": {"login": "o"}},
+		"installation": {"id": 99}
+	}`)
+	req := signedRequest(t, conf, "pull_request_review", body)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d, body: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if len(githubClients.requestedIDs) != 1 || githubClients.requestedIDs[0] != 99 {
+		t.Fatalf("requestedIDs = %v, want [99]", githubClients.requestedIDs)
+	}
+}
+
+func TestResolveClientUsesTheInstallationIDFromTheWebhookByDefault(t *testing.T) {
+	conf := Config{}
+	githubClients := &fakeGithubClients{client: github.NewClient(nil)}
+	body := []byte(`{"installation":{"id":13}}`)
+
+	if _, errResp := resolveClient(body, conf, githubClients); errResp != nil {
+		t.Fatalf("unexpected error: %+v", errResp)
+	}
+	if len(githubClients.requestedIDs) != 1 || githubClients.requestedIDs[0] != 13 {
+		t.Fatalf("requestedIDs = %v, want [13]", githubClients.requestedIDs)
+	}
+}
+
+func TestResolveClientConfiguredInstallationIDOverridesTheWebhookBody(t *testing.T) {
+	conf := Config{AppAuth: &AppAuth{InstallationID: 99}}
+	githubClients := &fakeGithubClients{client: github.NewClient(nil)}
+	body := []byte(`{"installation":{"id":13}}`)
+
+	if _, errResp := resolveClient(body, conf, githubClients); errResp != nil {
+		t.Fatalf("unexpected error: %+v", errResp)
+	}
+	if len(githubClients.requestedIDs) != 1 || githubClients.requestedIDs[0] != 99 {
+		t.Fatalf("requestedIDs = %v, want [99], the configured override should win", githubClients.requestedIDs)
+	}
+}
+
+func TestResolveClientPropagatesAClientResolutionError(t *testing.T) {
+	conf := Config{}
+	githubClients := &fakeGithubClients{clientErr: context.DeadlineExceeded}
+	body := []byte(`{"installation":{"id":13}}`)
+
+	_, errResp := resolveClient(body, conf, githubClients)
+	if errResp == nil {
+		t.Fatal("expected an error")
+	}
+	if errResp.Code != http.StatusBadGateway {
+		t.Fatalf("code = %d, want %d", errResp.Code, http.StatusBadGateway)
+	}
+}
+
+func TestResolveClientPropagatesAParseError(t *testing.T) {
+	conf := Config{}
+	githubClients := &fakeGithubClients{}
+
+	_, errResp := resolveClient([]byte("not json"), conf, githubClients)
+	if errResp == nil {
+		t.Fatal("expected an error")
+	}
+	if errResp.Code != http.StatusInternalServerError {
+		t.Fatalf("code = %d, want %d", errResp.Code, http.StatusInternalServerError)
+	}
+}
+
+func squashFixture() (IssueComment, *github.PullRequest) {
+	pr := &github.PullRequest{
+		Head: &github.PullRequestBranch{SHA: github.String("headsha"), Ref: github.String("feature")},
+		Base: &github.PullRequestBranch{SHA: github.String("basesha"), Ref: github.String("main")},
+	}
+	issueComment := IssueComment{IssueNumber: 1, Comment: "!squash", Repository: Repository{Owner: "o", Name: "r"}}
+	return issueComment, pr
+}
+
+func TestHandleSquashSkipsTheSigningGateWhenNotConfigured(t *testing.T) {
+	issueComment, pr := squashFixture()
+	fr := &fakeRepo{headSHA: "squashedsha"}
+	git := &fakeGit{repo: fr}
+	pullRequests := &fakePullRequests{pr: pr}
+	repositories := &fakeRepositories{}
+	conf := Config{}
+
+	resp := handleSquash(context.Background(), nil, issueComment, conf, git, pullRequests, repositories)
+	if _, ok := resp.(SuccessResponse); !ok {
+		t.Fatalf("expected a SuccessResponse, got %+v", resp)
+	}
+	if fr.signAmendCalls != 0 {
+		t.Fatalf("expected no re-signing without a configured SigningKeyPath, got %d calls", fr.signAmendCalls)
+	}
+	if len(fr.forcePushedTo) != 1 {
+		t.Fatalf("expected a single force-push, got %+v", fr.forcePushedTo)
+	}
+	status := repositories.lastStatus()
+	if status == nil || status.State == nil || *status.State != "success" {
+		t.Fatalf("expected a success review/squash status, got %+v", status)
+	}
+}
+
+func TestHandleSquashRefusesUnsignedCommitsWhenRequired(t *testing.T) {
+	issueComment, pr := squashFixture()
+	fr := &fakeRepo{headSHA: "squashedsha"}
+	git := &fakeGit{repo: fr}
+	pullRequests := &fakePullRequests{
+		pr:      pr,
+		commits: []*github.RepositoryCommit{{SHA: github.String("c1")}},
+	}
+	repositories := &fakeRepositories{
+		commits: map[string]*github.RepositoryCommit{
+			"c1": {SHA: github.String("c1"), Commit: &github.Commit{Verification: &github.SignatureVerification{Verified: github.Bool(false), Reason: github.String("unsigned")}}},
+		},
+	}
+	conf := Config{RequireSignedCommits: true}
+
+	resp := handleSquash(context.Background(), nil, issueComment, conf, git, pullRequests, repositories)
+	if _, ok := resp.(SuccessResponse); !ok {
+		t.Fatalf("expected a SuccessResponse (the refusal is reported via status), got %+v", resp)
+	}
+	if git.getCalls != 0 {
+		t.Fatal("expected squashing to be refused before the local repo is ever touched")
+	}
+	status := repositories.lastStatus()
+	if status == nil || status.State == nil || *status.State != "failure" {
+		t.Fatalf("expected a failure review/squash status, got %+v", status)
+	}
+}
+
+func TestHandleSquashProceedsWhenAllCommitsAreSigned(t *testing.T) {
+	issueComment, pr := squashFixture()
+	fr := &fakeRepo{headSHA: "squashedsha"}
+	git := &fakeGit{repo: fr}
+	pullRequests := &fakePullRequests{
+		pr:      pr,
+		commits: []*github.RepositoryCommit{{SHA: github.String("c1")}},
+	}
+	repositories := &fakeRepositories{
+		commits: map[string]*github.RepositoryCommit{
+			"c1": {SHA: github.String("c1"), Commit: &github.Commit{Verification: &github.SignatureVerification{Verified: github.Bool(true), Reason: github.String("valid")}}},
+		},
+	}
+	conf := Config{RequireSignedCommits: true}
+
+	resp := handleSquash(context.Background(), nil, issueComment, conf, git, pullRequests, repositories)
+	if _, ok := resp.(SuccessResponse); !ok {
+		t.Fatalf("expected a SuccessResponse, got %+v", resp)
+	}
+	status := repositories.lastStatus()
+	if status == nil || status.State == nil || *status.State != "success" {
+		t.Fatalf("expected a success review/squash status, got %+v", status)
+	}
+}
+
+func TestHandleSquashReSignsWhenASigningKeyIsConfigured(t *testing.T) {
+	issueComment, pr := squashFixture()
+	fr := &fakeRepo{headSHA: "squashedsha"}
+	git := &fakeGit{repo: fr}
+	pullRequests := &fakePullRequests{pr: pr}
+	repositories := &fakeRepositories{}
+	conf := Config{SigningKeyPath: "/tmp/key.asc", SigningKeyPassphrase: "s3cr3t"}
+
+	resp := handleSquash(context.Background(), nil, issueComment, conf, git, pullRequests, repositories)
+	if _, ok := resp.(SuccessResponse); !ok {
+		t.Fatalf("expected a SuccessResponse, got %+v", resp)
+	}
+	if fr.signAmendCalls != 1 {
+		t.Fatalf("expected the squashed commit to be re-signed once, got %d calls", fr.signAmendCalls)
+	}
+	if len(fr.forcePushedTo) != 2 {
+		t.Fatalf("expected a force-push before and after re-signing, got %+v", fr.forcePushedTo)
+	}
+}
+
+func TestHandleSquashReportsAConflictFromRebaseAutosquash(t *testing.T) {
+	issueComment, pr := squashFixture()
+	conflict := RebaseConflict{Files: []string{"a.txt"}, SHA: "fixupsha"}
+	fr := &fakeRepo{rebaseAutosquashErr: &RebaseConflictError{Conflict: conflict}}
+	git := &fakeGit{repo: fr}
+	pullRequests := &fakePullRequests{pr: pr}
+	repositories := &fakeRepositories{}
+
+	resp := handleSquash(context.Background(), nil, issueComment, Config{}, git, pullRequests, repositories)
+	if _, ok := resp.(SuccessResponse); !ok {
+		t.Fatalf("expected a SuccessResponse, got %+v", resp)
+	}
+	if len(fr.forcePushedTo) != 0 {
+		t.Fatalf("expected no force-push after a conflict, got %+v", fr.forcePushedTo)
+	}
+	status := repositories.lastStatus()
+	if status == nil || status.State == nil || *status.State != "failure" {
+		t.Fatalf("expected a failure review/squash status, got %+v", status)
+	}
+	if status.Description == nil || *status.Description == "" {
+		t.Fatal("expected the conflict message to be included in the status")
+	}
+}
+
+func TestHandleInstallationEvent(t *testing.T) {
+	tests := []struct {
+		action          string
+		wantInvalidated bool
+	}{
+		{action: "created"},
+		{action: "deleted", wantInvalidated: true},
+		{action: "suspend", wantInvalidated: true},
+		{action: "unsuspend"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.action, func(t *testing.T) {
+			githubClients := &fakeGithubClients{}
+			body := []byte(`{"action":"` + tt.action + `","installation":{"id":42}}`)
+
+			resp := handleInstallationEvent(body, githubClients)
+			if _, ok := resp.(SuccessResponse); !ok {
+				t.Fatalf("expected a SuccessResponse, got %+v", resp)
+			}
+			if tt.wantInvalidated {
+				if len(githubClients.invalidated) != 1 || githubClients.invalidated[0] != 42 {
+					t.Fatalf("invalidated = %v, want [42]", githubClients.invalidated)
+				}
+			} else if len(githubClients.invalidated) != 0 {
+				t.Fatalf("expected no invalidation for action %q, got %v", tt.action, githubClients.invalidated)
+			}
+		})
+	}
+}
+
+func TestHandleInstallationRepositoriesEvent(t *testing.T) {
+	tests := []struct {
+		action          string
+		wantInvalidated bool
+	}{
+		{action: "added"},
+		{action: "removed", wantInvalidated: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.action, func(t *testing.T) {
+			githubClients := &fakeGithubClients{}
+			body := []byte(`{"action":"` + tt.action + `","installation":{"id":7}}`)
+
+			resp := handleInstallationRepositoriesEvent(body, githubClients)
+			if _, ok := resp.(SuccessResponse); !ok {
+				t.Fatalf("expected a SuccessResponse, got %+v", resp)
+			}
+			if tt.wantInvalidated {
+				if len(githubClients.invalidated) != 1 || githubClients.invalidated[0] != 7 {
+					t.Fatalf("invalidated = %v, want [7]", githubClients.invalidated)
+				}
+			} else if len(githubClients.invalidated) != 0 {
+				t.Fatalf("expected no invalidation for action %q, got %v", tt.action, githubClients.invalidated)
+			}
+		})
+	}
+}
+
+func TestParseIssueComment(t *testing.T) {
+	body := []byte(`{
+		"issue": {"Number": 5, "pull_request": {"url": "https://api.github.com/repos/o/r/pulls/5"}},
+		"repository": {"name": "r", "owner": {"login": "o"}, "ssh_url": "git@github.com:o/r.git"},
+		"comment": {"body": "!squash", "user": {"login": "alice"}}
+	}`)
+
+	issueComment, err := parseIssueComment(body)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := IssueComment{
+		IssueNumber:    5,
+		Comment:        "!squash",
+		CommenterLogin: "alice",
+		IsPullRequest:  true,
+		Repository:     Repository{Owner: "o", Name: "r", URL: "git@github.com:o/r.git"},
+	}
+	if issueComment != want {
+		t.Fatalf("issueComment = %+v, want %+v", issueComment, want)
+	}
+}
+
+func TestParseIssueCommentOnAPlainIssue(t *testing.T) {
+	body := []byte(`{
+		"issue": {"Number": 5},
+		"repository": {"name": "r", "owner": {"login": "o"}},
+		"comment": {"body": "hello", "user": {"login": "alice"}}
+	}`)
+
+	issueComment, err := parseIssueComment(body)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if issueComment.IsPullRequest {
+		t.Fatal("expected a comment on a plain issue to not be flagged as a PR")
+	}
+}
+
+func TestParsePullRequestEvent(t *testing.T) {
+	body := []byte(`{
+		"action": "synchronize",
+		"number": 9,
+		"repository": {"name": "r", "owner": {"login": "o"}, "ssh_url": "git@github.com:o/r.git"}
+	}`)
+
+	event, err := parsePullRequestEvent(body)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := PullRequestEvent{
+		IssueNumber: 9,
+		Action:      "synchronize",
+		Repository:  Repository{Owner: "o", Name: "r", URL: "git@github.com:o/r.git"},
+	}
+	if event != want {
+		t.Fatalf("event = %+v, want %+v", event, want)
+	}
+}
+
+func TestHandlePlusOneRecordsApprovalAndSetsPeerReviewStatus(t *testing.T) {
+	pr := &github.PullRequest{Head: &github.PullRequestBranch{SHA: github.String("headsha")}}
+	issueComment := IssueComment{IssueNumber: 1, CommenterLogin: "alice", Repository: Repository{Owner: "o", Name: "r"}}
+	pullRequests := &fakePullRequests{pr: pr}
+	repositories := &fakeRepositories{}
+	approvals := newApprovalTracker()
+	conf := Config{RequiredApprovals: 2}
+
+	resp := handlePlusOne(context.Background(), nil, issueComment, conf, approvals, pullRequests, repositories)
+	if _, ok := resp.(SuccessResponse); !ok {
+		t.Fatalf("expected a SuccessResponse, got %+v", resp)
+	}
+	status := repositories.lastStatus()
+	if status == nil || status.State == nil || *status.State != "pending" {
+		t.Fatalf("expected a pending review/peer status with one approval short of the threshold, got %+v", status)
+	}
+
+	resp = handlePlusOne(context.Background(), nil, IssueComment{IssueNumber: 1, CommenterLogin: "bob", Repository: issueComment.Repository}, conf, approvals, pullRequests, repositories)
+	if _, ok := resp.(SuccessResponse); !ok {
+		t.Fatalf("expected a SuccessResponse, got %+v", resp)
+	}
+	status = repositories.lastStatus()
+	if status == nil || status.State == nil || *status.State != "success" {
+		t.Fatalf("expected a success review/peer status once both approvals are in, got %+v", status)
+	}
+}
+
+func TestParseInstallationID(t *testing.T) {
+	id, err := parseInstallationID([]byte(`{"installation":{"id":123}}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if id != 123 {
+		t.Fatalf("id = %d, want 123", id)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+const githubStatusMergeContext = "review/merge"
+
+const (
+	maxMergeabilityAttempts = 5
+	mergeabilityBackoffBase = 2 * time.Second
+)
+
+func handleMerge(ctx context.Context, issueComment IssueComment, conf Config, pullRequests PullRequests, merger Merger, repositories Repositories, references References) Response {
+	pr, errResp := getPR(ctx, issueComment, pullRequests)
+	if errResp != nil {
+		return errResp
+	}
+	repository := issueComment.Issue().Repository
+
+	combined, _, err := repositories.GetCombinedStatus(ctx, repository.Owner, repository.Name, *pr.Head.SHA, nil)
+	if err != nil {
+		message := fmt.Sprintf("Getting the combined status for PR %s failed", issueComment.Issue().FullName())
+		return &ErrorResponse{err, http.StatusBadGateway, message}
+	}
+	if !requiredStatusesGreen(combined) {
+		return reportMergeFailure(ctx, issueComment, *pr.Head.SHA, repositories, "Waiting on review/squash, review/peer and all required checks to pass")
+	}
+
+	mergeableState := "unknown"
+	if pr.MergeableState != nil {
+		mergeableState = *pr.MergeableState
+	}
+	if mergeableState == "unknown" {
+		// GitHub computes mergeability asynchronously, so it's often still
+		// "unknown" right after a push. Polling for it here would block
+		// this webhook request for up to the full backoff, risking GitHub
+		// considering the delivery timed out and redelivering the same
+		// issue_comment event. Finish the poll-and-merge in the
+		// background instead and acknowledge the webhook immediately.
+		log.Printf("Mergeable state for %s isn't ready yet; resolving it in the background\n", issueComment.Issue().FullName())
+		go resolveAndMerge(issueComment, conf, pullRequests, merger, repositories, references)
+		return SuccessResponse{"GitHub hasn't finished computing whether this PR is mergeable yet. Retrying in the background"}
+	}
+	return completeMerge(ctx, issueComment, conf, pr, mergeableState, merger, repositories, references)
+}
+
+// resolveAndMerge polls for a PR's mergeable_state and completes the merge
+// once GitHub has finished computing it. It runs detached from the
+// webhook request that triggered it, since that request has already been
+// acknowledged, and reports its own failure status rather than returning
+// a Response to anyone.
+func resolveAndMerge(issueComment IssueComment, conf Config, pullRequests PullRequests, merger Merger, repositories Repositories, references References) {
+	ctx := context.Background()
+	pr, mergeableState, err := resolveMergeableState(ctx, issueComment, pullRequests)
+	if err != nil {
+		log.Printf("Failed to determine whether %s is mergeable: %s\n", issueComment.Issue().FullName(), err)
+		return
+	}
+	if resp, ok := completeMerge(ctx, issueComment, conf, pr, mergeableState, merger, repositories, references).(*ErrorResponse); ok {
+		log.Printf("Background merge of %s failed: %s\n", issueComment.Issue().FullName(), resp.Err)
+	}
+}
+
+// completeMerge carries out the merge for a PR whose mergeable_state is
+// already known: reject it if the state isn't clean (or unstable with
+// AllowUnstable), merge it otherwise, and report the outcome as a
+// review/merge status.
+func completeMerge(ctx context.Context, issueComment IssueComment, conf Config, pr *github.PullRequest, mergeableState string, merger Merger, repositories Repositories, references References) Response {
+	repository := issueComment.Issue().Repository
+	switch mergeableState {
+	case "clean":
+	case "unstable":
+		if !conf.AllowUnstable {
+			return reportMergeFailure(ctx, issueComment, *pr.Head.SHA, repositories, "PR's merge state is \"unstable\"")
+		}
+	case "unknown":
+		return reportMergeFailure(ctx, issueComment, *pr.Head.SHA, repositories, "GitHub hasn't finished computing whether this PR is mergeable yet. Please try again")
+	default:
+		return reportMergeFailure(ctx, issueComment, *pr.Head.SHA, repositories, fmt.Sprintf("PR's merge state is %q", mergeableState))
+	}
+
+	log.Printf("Merging %s with method %q\n", issueComment.Issue().FullName(), conf.MergeMethod)
+	options := &github.PullRequestOptions{MergeMethod: conf.MergeMethod}
+	result, _, err := merger.Merge(ctx, repository.Owner, repository.Name, issueComment.IssueNumber, "", options)
+	if err != nil {
+		message := fmt.Sprintf("Merging PR %s failed", issueComment.Issue().FullName())
+		return &ErrorResponse{err, http.StatusBadGateway, message}
+	}
+	if result.Merged == nil || !*result.Merged {
+		reason := "GitHub refused to merge the PR"
+		if result.Message != nil {
+			reason = *result.Message
+		}
+		return reportMergeFailure(ctx, issueComment, *pr.Head.SHA, repositories, reason)
+	}
+
+	status := createMergeStatus("success", "This PR has been merged")
+	if errResp := setStatus(ctx, repository, *pr.Head.SHA, status, repositories); errResp != nil {
+		return errResp
+	}
+	if conf.DeleteHeadBranchOnMerge && pr.Head.Ref != nil {
+		if _, err := references.DeleteRef(ctx, repository.Owner, repository.Name, "heads/"+*pr.Head.Ref); err != nil {
+			log.Printf("Failed to delete the head branch %s after merging: %s\n", *pr.Head.Ref, err)
+		}
+	}
+	return SuccessResponse{}
+}
+
+// requiredStatusesGreen reports whether the review/squash and review/peer
+// statuses, as well as the overall combined state (which GitHub rolls
+// required status checks into), are all successful.
+func requiredStatusesGreen(combined *github.CombinedStatus) bool {
+	if combined.State == nil || *combined.State != "success" {
+		return false
+	}
+	return statusState(combined, githubStatusSquashContext) == "success" &&
+		statusState(combined, githubStatusPeerReviewContext) == "success"
+}
+
+func statusState(combined *github.CombinedStatus, context string) string {
+	for _, status := range combined.Statuses {
+		if status.Context != nil && *status.Context == context {
+			if status.State == nil {
+				return ""
+			}
+			return *status.State
+		}
+	}
+	return ""
+}
+
+// resolveMergeableState polls the PR until GitHub has finished computing
+// mergeability, since it's done asynchronously and starts out as
+// "unknown" right after a push.
+func resolveMergeableState(ctx context.Context, issueComment IssueComment, pullRequests PullRequests) (*github.PullRequest, string, error) {
+	var pr *github.PullRequest
+	for attempt := 0; attempt < maxMergeabilityAttempts; attempt++ {
+		fetched, errResp := getPR(ctx, issueComment, pullRequests)
+		if errResp != nil {
+			return nil, "", errResp.Err
+		}
+		pr = fetched
+		if pr.MergeableState == nil {
+			return pr, "unknown", nil
+		}
+		if *pr.MergeableState != "unknown" {
+			return pr, *pr.MergeableState, nil
+		}
+		time.Sleep(mergeabilityBackoffBase * time.Duration(1<<uint(attempt)))
+	}
+	return pr, "unknown", nil
+}
+
+func reportMergeFailure(ctx context.Context, issueComment IssueComment, headSHA string, repositories Repositories, reason string) Response {
+	log.Printf("Refusing to merge %s: %s\n", issueComment.Issue().FullName(), reason)
+	status := createMergeStatus("failure", reason)
+	if errResp := setStatus(ctx, issueComment.Issue().Repository, headSHA, status, repositories); errResp != nil {
+		return errResp
+	}
+	return SuccessResponse{fmt.Sprintf("Not merging: %s", reason)}
+}
+
+func createMergeStatus(state, description string) *github.RepoStatus {
+	return &github.RepoStatus{
+		State:       github.String(state),
+		Description: github.String(description),
+		Context:     github.String(githubStatusMergeContext),
+	}
+}
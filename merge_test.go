@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+func greenCombined() *github.CombinedStatus {
+	return &github.CombinedStatus{
+		State: github.String("success"),
+		Statuses: []github.RepoStatus{
+			{Context: github.String(githubStatusSquashContext), State: github.String("success")},
+			{Context: github.String(githubStatusPeerReviewContext), State: github.String("success")},
+		},
+	}
+}
+
+func TestRequiredStatusesGreen(t *testing.T) {
+	if !requiredStatusesGreen(greenCombined()) {
+		t.Fatal("expected a fully green combined status to be green")
+	}
+	red := greenCombined()
+	red.Statuses[0].State = github.String("pending")
+	if requiredStatusesGreen(red) {
+		t.Fatal("expected a pending review/squash status to not be green")
+	}
+}
+
+func TestCompleteMergeGatingMatrix(t *testing.T) {
+	tests := []struct {
+		name           string
+		mergeableState string
+		allowUnstable  bool
+		wantMerged     bool
+		wantReason     string
+	}{
+		{name: "clean merges", mergeableState: "clean", wantMerged: true},
+		{name: "unstable without AllowUnstable fails", mergeableState: "unstable", wantReason: "unstable"},
+		{name: "unstable with AllowUnstable merges", mergeableState: "unstable", allowUnstable: true, wantMerged: true},
+		{name: "dirty fails", mergeableState: "dirty", wantReason: `"dirty"`},
+		{name: "blocked fails", mergeableState: "blocked", wantReason: `"blocked"`},
+		{name: "behind fails", mergeableState: "behind", wantReason: `"behind"`},
+		{name: "unknown fails", mergeableState: "unknown", wantReason: "hasn't finished computing"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pr := &github.PullRequest{Head: &github.PullRequestBranch{SHA: github.String("headsha"), Ref: github.String("feature")}}
+			merger := &fakeMerger{result: &github.PullRequestMergeResult{Merged: github.Bool(true)}}
+			repositories := &fakeRepositories{}
+			references := &fakeReferences{}
+			conf := Config{MergeMethod: "merge", AllowUnstable: tt.allowUnstable}
+			issueComment := IssueComment{IssueNumber: 1, Repository: Repository{Owner: "o", Name: "r"}}
+
+			completeMerge(context.Background(), issueComment, conf, pr, tt.mergeableState, merger, repositories, references)
+
+			if tt.wantMerged {
+				if merger.calls != 1 {
+					t.Fatalf("expected the PR to be merged, got %d merge calls", merger.calls)
+				}
+				status := repositories.lastStatus()
+				if status == nil || status.State == nil || *status.State != "success" {
+					t.Fatalf("expected a success review/merge status, got %+v", status)
+				}
+				return
+			}
+			if merger.calls != 0 {
+				t.Fatalf("expected the PR not to be merged, got %d merge calls", merger.calls)
+			}
+			status := repositories.lastStatus()
+			if status == nil || status.State == nil || *status.State != "failure" {
+				t.Fatalf("expected a failure review/merge status, got %+v", status)
+			}
+			if status.Description == nil || !strings.Contains(*status.Description, tt.wantReason) {
+				t.Errorf("status description %q doesn't mention %q", status.GetDescription(), tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestCompleteMergeDeletesHeadBranchWhenConfigured(t *testing.T) {
+	pr := &github.PullRequest{Head: &github.PullRequestBranch{SHA: github.String("headsha"), Ref: github.String("feature")}}
+	merger := &fakeMerger{result: &github.PullRequestMergeResult{Merged: github.Bool(true)}}
+	references := &fakeReferences{}
+	conf := Config{MergeMethod: "merge", DeleteHeadBranchOnMerge: true}
+	issueComment := IssueComment{IssueNumber: 1, Repository: Repository{Owner: "o", Name: "r"}}
+
+	completeMerge(context.Background(), issueComment, conf, pr, "clean", merger, &fakeRepositories{}, references)
+
+	if len(references.deleted) != 1 || references.deleted[0] != "heads/feature" {
+		t.Fatalf("expected heads/feature to be deleted, got %+v", references.deleted)
+	}
+}
+
+func TestResolveMergeableStateReturnsImmediatelyWhenKnown(t *testing.T) {
+	pr := &github.PullRequest{MergeableState: github.String("clean")}
+	pullRequests := &fakePullRequests{pr: pr}
+
+	start := time.Now()
+	resolved, state, err := resolveMergeableState(context.Background(), IssueComment{}, pullRequests)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if state != "clean" {
+		t.Fatalf("state = %q, want clean", state)
+	}
+	if resolved != pr {
+		t.Fatalf("expected the resolved PR to be returned")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected no backoff when already resolved, took %s", elapsed)
+	}
+	if pullRequests.getCalls != 1 {
+		t.Fatalf("expected exactly one Get call, got %d", pullRequests.getCalls)
+	}
+}
+
+func TestResolveMergeableStateRetriesUntilResolved(t *testing.T) {
+	pullRequests := &fakePullRequests{
+		prSequence: []*github.PullRequest{
+			{MergeableState: github.String("unknown")},
+			{MergeableState: github.String("clean")},
+		},
+	}
+
+	_, state, err := resolveMergeableState(context.Background(), IssueComment{}, pullRequests)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if state != "clean" {
+		t.Fatalf("state = %q, want clean", state)
+	}
+	if pullRequests.getCalls != 2 {
+		t.Fatalf("expected two Get calls, got %d", pullRequests.getCalls)
+	}
+}
+
+func TestHandleMergeResolvesUnknownStateInTheBackground(t *testing.T) {
+	pr := &github.PullRequest{
+		MergeableState: github.String("unknown"),
+		Head:           &github.PullRequestBranch{SHA: github.String("headsha"), Ref: github.String("feature")},
+	}
+	pullRequests := &fakePullRequests{
+		prSequence: []*github.PullRequest{pr, {MergeableState: github.String("clean"), Head: pr.Head}},
+	}
+	repositories := &fakeRepositories{combined: greenCombined()}
+	mergeCh := make(chan struct{})
+	merger := &fakeMerger{result: &github.PullRequestMergeResult{Merged: github.Bool(true)}, mergeCh: mergeCh}
+	conf := Config{MergeMethod: "merge"}
+	issueComment := IssueComment{IssueNumber: 1, Repository: Repository{Owner: "o", Name: "r"}}
+
+	resp := handleMerge(context.Background(), issueComment, conf, pullRequests, merger, repositories, &fakeReferences{})
+	if _, ok := resp.(SuccessResponse); !ok {
+		t.Fatalf("expected handleMerge to return immediately with a SuccessResponse, got %+v", resp)
+	}
+
+	select {
+	case <-mergeCh:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the background retry to eventually merge the PR")
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/google/go-github/github"
+)
+
+const githubStatusRebaseContext = "review/rebase"
+
+func createRebaseStatus(state, description string) *github.RepoStatus {
+	return &github.RepoStatus{
+		State:       github.String(state),
+		Description: github.String(description),
+		Context:     github.String(githubStatusRebaseContext),
+	}
+}
+
+func conflictMessage(conflict RebaseConflict) string {
+	return fmt.Sprintf("Conflict in %s while applying %s. Please rebase manually", strings.Join(conflict.Files, ", "), conflict.SHA)
+}
+
+func handleRebase(ctx context.Context, issueComment IssueComment, git Git, pullRequests PullRequests, repositories Repositories) Response {
+	pr, errResp := getPR(ctx, issueComment, pullRequests)
+	if errResp != nil {
+		return errResp
+	}
+	log.Printf("Rebasing %s onto %s\n", issueComment.Issue().FullName(), *pr.Base.Ref)
+	repo, err := git.GetUpdatedRepo(issueComment.Repository.URL, issueComment.Repository.Owner, issueComment.Repository.Name)
+	if err != nil {
+		return ErrorResponse{err, http.StatusInternalServerError, "Failed to update the local repo"}
+	}
+	result, err := repo.Rebase(*pr.Base.Ref, *pr.Head.SHA)
+	if err != nil {
+		return ErrorResponse{err, http.StatusInternalServerError, "Failed to rebase the branch"}
+	}
+	if result.Conflict != nil {
+		status := createRebaseStatus("failure", conflictMessage(*result.Conflict))
+		if errResp := setStatus(ctx, issueComment.Repository, *pr.Head.SHA, status, repositories); errResp != nil {
+			return errResp
+		}
+		return SuccessResponse{"Rebase produced a conflict. Reported the failure and left the working copy clean."}
+	}
+	if err = repo.ForcePushHeadTo(*pr.Head.Ref); err != nil {
+		return ErrorResponse{err, http.StatusInternalServerError, "Failed to push the rebased version"}
+	}
+	rebasedHeadSHA, err := repo.GetHeadSHA()
+	if err != nil {
+		return ErrorResponse{err, http.StatusInternalServerError, "Failed to get the rebased branch's HEAD's SHA"}
+	}
+	status := createRebaseStatus("success", "Successfully rebased onto the latest base branch")
+	if errResp := setStatus(ctx, issueComment.Repository, rebasedHeadSHA, status, repositories); errResp != nil {
+		return errResp
+	}
+	return SuccessResponse{}
+}
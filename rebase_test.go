@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/github"
+)
+
+func TestParseRebaseConflict(t *testing.T) {
+	r := &repo{Dir: t.TempDir()}
+
+	tests := []struct {
+		name      string
+		out       string
+		wantFound bool
+		wantFiles []string
+	}{
+		{
+			name:      "content conflict",
+			out:       "Auto-merging shared.txt\nCONFLICT (content): Merge conflict in shared.txt\nerror: could not apply abc123... the commit message",
+			wantFound: true,
+			wantFiles: []string{"shared.txt"},
+		},
+		{
+			name:      "modify/delete conflict",
+			out:       "CONFLICT (modify/delete): removed.txt deleted in HEAD and modified in abc123. Version abc123 of removed.txt left in tree.",
+			wantFound: true,
+			wantFiles: []string{"removed.txt"},
+		},
+		{
+			name:      "multiple conflicts",
+			out:       "CONFLICT (content): Merge conflict in a.txt\nCONFLICT (content): Merge conflict in b.txt",
+			wantFound: true,
+			wantFiles: []string{"a.txt", "b.txt"},
+		},
+		{
+			name:      "no conflict",
+			out:       "Applying: some commit\n",
+			wantFound: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conflict, ok := r.parseRebaseConflict([]byte(tt.out))
+			if ok != tt.wantFound {
+				t.Fatalf("found = %v, want %v", ok, tt.wantFound)
+			}
+			if !ok {
+				return
+			}
+			if len(conflict.Files) != len(tt.wantFiles) {
+				t.Fatalf("files = %v, want %v", conflict.Files, tt.wantFiles)
+			}
+			for i, f := range tt.wantFiles {
+				if conflict.Files[i] != f {
+					t.Errorf("files[%d] = %q, want %q", i, conflict.Files[i], f)
+				}
+			}
+		})
+	}
+}
+
+// rebaseFixture is a clone of a bare origin repo, with commits added to
+// drive repo.Rebase through deliberately conflicting and non-conflicting
+// scenarios.
+type rebaseFixture struct {
+	t     *testing.T
+	clone string
+}
+
+func newRebaseFixture(t *testing.T) *rebaseFixture {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+	origin := t.TempDir()
+	f := &rebaseFixture{t: t, clone: t.TempDir()}
+	f.run("", "init", "--bare", "--initial-branch=main", origin)
+	f.run("", "clone", origin, f.clone)
+	f.run(f.clone, "config", "user.email", "test@example.com")
+	f.run(f.clone, "config", "user.name", "Test")
+	f.writeFile("shared.txt", "base\n")
+	f.run(f.clone, "add", "shared.txt")
+	f.run(f.clone, "commit", "-m", "base commit")
+	f.run(f.clone, "push", "origin", "HEAD:refs/heads/main")
+	return f
+}
+
+func (f *rebaseFixture) run(dir string, args ...string) {
+	f.t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		f.t.Fatalf("git %s failed: %s: %s", strings.Join(args, " "), err, out)
+	}
+}
+
+func (f *rebaseFixture) writeFile(name, contents string) {
+	f.t.Helper()
+	if err := os.WriteFile(filepath.Join(f.clone, name), []byte(contents), 0644); err != nil {
+		f.t.Fatalf("writing %s: %s", name, err)
+	}
+}
+
+func (f *rebaseFixture) headSHA() string {
+	f.t.Helper()
+	cmd := exec.Command("git", "rev-parse", "HEAD")
+	cmd.Dir = f.clone
+	out, err := cmd.Output()
+	if err != nil {
+		f.t.Fatalf("git rev-parse HEAD: %s", err)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func TestRepoRebaseReportsConflictAndLeavesACleanWorkingCopy(t *testing.T) {
+	f := newRebaseFixture(t)
+
+	f.run(f.clone, "checkout", "-b", "feature")
+	f.writeFile("shared.txt", "feature change\n")
+	f.run(f.clone, "commit", "-am", "feature commit")
+	featureSHA := f.headSHA()
+	f.run(f.clone, "push", "origin", "HEAD:refs/heads/feature")
+
+	f.run(f.clone, "checkout", "main")
+	f.writeFile("shared.txt", "main change\n")
+	f.run(f.clone, "commit", "-am", "conflicting base commit")
+	f.run(f.clone, "push", "origin", "main")
+
+	f.run(f.clone, "checkout", "feature")
+
+	r := &repo{Dir: f.clone}
+	result, err := r.Rebase("main", featureSHA)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if result.Conflict == nil {
+		t.Fatal("expected a conflict, got none")
+	}
+	if len(result.Conflict.Files) != 1 || result.Conflict.Files[0] != "shared.txt" {
+		t.Fatalf("conflict.Files = %v, want [shared.txt]", result.Conflict.Files)
+	}
+	if result.Conflict.SHA != featureSHA {
+		t.Fatalf("conflict.SHA = %q, want %q", result.Conflict.SHA, featureSHA)
+	}
+
+	status := exec.Command("git", "status", "--porcelain")
+	status.Dir = f.clone
+	out, err := status.Output()
+	if err != nil {
+		t.Fatalf("git status: %s", err)
+	}
+	if len(strings.TrimSpace(string(out))) != 0 {
+		t.Fatalf("expected a clean working copy after the aborted rebase, got: %s", out)
+	}
+	if sha := f.headSHA(); sha != featureSHA {
+		t.Fatalf("expected HEAD back at the pre-rebase feature commit %q, got %q", featureSHA, sha)
+	}
+}
+
+func TestRepoRebaseSucceedsWithoutConflict(t *testing.T) {
+	f := newRebaseFixture(t)
+
+	f.run(f.clone, "checkout", "-b", "feature")
+	f.writeFile("feature.txt", "feature change\n")
+	f.run(f.clone, "add", "feature.txt")
+	f.run(f.clone, "commit", "-m", "feature commit")
+	featureSHA := f.headSHA()
+	f.run(f.clone, "push", "origin", "HEAD:refs/heads/feature")
+
+	f.run(f.clone, "checkout", "main")
+	f.writeFile("unrelated.txt", "unrelated change\n")
+	f.run(f.clone, "add", "unrelated.txt")
+	f.run(f.clone, "commit", "-m", "unrelated base commit")
+	f.run(f.clone, "push", "origin", "main")
+
+	f.run(f.clone, "checkout", "feature")
+
+	r := &repo{Dir: f.clone}
+	result, err := r.Rebase("main", featureSHA)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if result.Conflict != nil {
+		t.Fatalf("expected no conflict, got %+v", result.Conflict)
+	}
+	if sha := f.headSHA(); sha == featureSHA {
+		t.Fatal("expected the rebase to produce a new commit on top of the updated base")
+	}
+}
+
+func TestHandleRebaseSucceeds(t *testing.T) {
+	pr := &github.PullRequest{
+		Base: &github.PullRequestBranch{Ref: github.String("main")},
+		Head: &github.PullRequestBranch{SHA: github.String("headsha"), Ref: github.String("feature")},
+	}
+	fr := &fakeRepo{headSHA: "rebasedsha"}
+	git := &fakeGit{repo: fr}
+	pullRequests := &fakePullRequests{pr: pr}
+	repositories := &fakeRepositories{}
+	issueComment := IssueComment{IssueNumber: 1, Repository: Repository{Owner: "o", Name: "r"}}
+
+	resp := handleRebase(context.Background(), issueComment, git, pullRequests, repositories)
+	if _, ok := resp.(SuccessResponse); !ok {
+		t.Fatalf("expected a SuccessResponse, got %+v", resp)
+	}
+	if len(fr.forcePushedTo) != 1 || fr.forcePushedTo[0] != "feature" {
+		t.Fatalf("expected a force-push to feature, got %+v", fr.forcePushedTo)
+	}
+	status := repositories.lastStatus()
+	if status == nil || status.State == nil || *status.State != "success" {
+		t.Fatalf("expected a success review/rebase status, got %+v", status)
+	}
+	if status.Context == nil || *status.Context != githubStatusRebaseContext {
+		t.Fatalf("expected the review/rebase context, got %+v", status.Context)
+	}
+}
+
+func TestHandleRebaseReportsConflictAndDoesNotPush(t *testing.T) {
+	pr := &github.PullRequest{
+		Base: &github.PullRequestBranch{Ref: github.String("main")},
+		Head: &github.PullRequestBranch{SHA: github.String("headsha"), Ref: github.String("feature")},
+	}
+	conflict := RebaseConflict{Files: []string{"shared.txt"}, SHA: "fixupsha"}
+	fr := &fakeRepo{rebaseResult: RebaseResult{Conflict: &conflict}}
+	git := &fakeGit{repo: fr}
+	pullRequests := &fakePullRequests{pr: pr}
+	repositories := &fakeRepositories{}
+	issueComment := IssueComment{IssueNumber: 1, Repository: Repository{Owner: "o", Name: "r"}}
+
+	resp := handleRebase(context.Background(), issueComment, git, pullRequests, repositories)
+	if _, ok := resp.(SuccessResponse); !ok {
+		t.Fatalf("expected a SuccessResponse (the conflict is reported via status, not an HTTP error), got %+v", resp)
+	}
+	if len(fr.forcePushedTo) != 0 {
+		t.Fatalf("expected no force-push on conflict, got %+v", fr.forcePushedTo)
+	}
+	status := repositories.lastStatus()
+	if status == nil || status.State == nil || *status.State != "failure" {
+		t.Fatalf("expected a failure review/rebase status, got %+v", status)
+	}
+	if status.Description == nil || !strings.Contains(*status.Description, "shared.txt") || !strings.Contains(*status.Description, "fixupsha") {
+		t.Errorf("status description %q doesn't mention the conflicting file or SHA", status.GetDescription())
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"net/http"
+	"strings"
+)
+
+// verifyWebhookSignature checks the request against GitHub's preferred
+// X-Hub-Signature-256 (HMAC-SHA256) header, falling back to the legacy
+// X-Hub-Signature (HMAC-SHA1) header only when AllowLegacySHA1 is set.
+// Returns nil if the request is authenticated.
+func verifyWebhookSignature(body []byte, header http.Header, conf Config) *ErrorResponse {
+	if signature256 := header.Get("X-Hub-Signature-256"); signature256 != "" {
+		ok, err := hasSecretSHA256(body, signature256, conf.Secret)
+		if err != nil {
+			return &ErrorResponse{err, http.StatusInternalServerError, "Failed to check the signature"}
+		} else if !ok {
+			return &ErrorResponse{nil, http.StatusForbidden, "Bad X-Hub-Signature-256"}
+		}
+		return nil
+	}
+
+	signature1 := header.Get("X-Hub-Signature")
+	if signature1 == "" {
+		return &ErrorResponse{nil, http.StatusUnauthorized, "Please provide a X-Hub-Signature-256"}
+	}
+	if conf.RequireSHA256 {
+		return &ErrorResponse{nil, http.StatusUnauthorized, "X-Hub-Signature-256 is required; X-Hub-Signature (SHA1) is not accepted"}
+	}
+	if !conf.AllowLegacySHA1 {
+		return &ErrorResponse{nil, http.StatusUnauthorized, "X-Hub-Signature (SHA1) is disabled; please configure X-Hub-Signature-256"}
+	}
+	ok, err := hasSecretSHA1(body, signature1, conf.Secret)
+	if err != nil {
+		return &ErrorResponse{err, http.StatusInternalServerError, "Failed to check the signature"}
+	} else if !ok {
+		return &ErrorResponse{nil, http.StatusForbidden, "Bad X-Hub-Signature"}
+	}
+	return nil
+}
+
+func hasSecretSHA1(message []byte, signature, key string) (bool, error) {
+	return hmacEqual(sha1.New, key, message, signature, "sha1=")
+}
+
+func hasSecretSHA256(message []byte, signature, key string) (bool, error) {
+	return hmacEqual(sha256.New, key, message, signature, "sha256=")
+}
+
+func hmacEqual(hashFunc func() hash.Hash, key string, message []byte, signature, prefix string) (bool, error) {
+	if !strings.HasPrefix(signature, prefix) {
+		return false, nil
+	}
+	messageMAC, err := hex.DecodeString(strings.TrimPrefix(signature, prefix))
+	if err != nil {
+		return false, err
+	}
+	mac := hmac.New(hashFunc, []byte(key))
+	mac.Write(message)
+	expectedMAC := mac.Sum(nil)
+	return hmac.Equal(messageMAC, expectedMAC), nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"net/http"
+	"testing"
+)
+
+func sign(hashFunc func() hash.Hash, prefix, key string, body []byte) string {
+	mac := hmac.New(hashFunc, []byte(key))
+	mac.Write(body)
+	return prefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyWebhookSignature(t *testing.T) {
+	const secret = "s3cr3t"
+	body := []byte(`{"hello":"world"}`)
+
+	tests := []struct {
+		name    string
+		header  http.Header
+		conf    Config
+		wantErr bool
+	}{
+		{
+			name:   "valid 256",
+			header: http.Header{"X-Hub-Signature-256": {sign(sha256.New, "sha256=", secret, body)}},
+			conf:   Config{Secret: secret},
+		},
+		{
+			name:   "valid 1 with legacy allowed",
+			header: http.Header{"X-Hub-Signature": {sign(sha1.New, "sha1=", secret, body)}},
+			conf:   Config{Secret: secret, AllowLegacySHA1: true},
+		},
+		{
+			name:    "valid 1 with legacy disallowed",
+			header:  http.Header{"X-Hub-Signature": {sign(sha1.New, "sha1=", secret, body)}},
+			conf:    Config{Secret: secret, AllowLegacySHA1: false},
+			wantErr: true,
+		},
+		{
+			name:    "mismatched digest",
+			header:  http.Header{"X-Hub-Signature-256": {sign(sha256.New, "sha256=", "wrong-secret", body)}},
+			conf:    Config{Secret: secret},
+			wantErr: true,
+		},
+		{
+			name:    "truncated hex",
+			header:  http.Header{"X-Hub-Signature-256": {"sha256=abcd"}},
+			conf:    Config{Secret: secret},
+			wantErr: true,
+		},
+		{
+			name:    "header with wrong algorithm prefix",
+			header:  http.Header{"X-Hub-Signature-256": {sign(sha1.New, "sha1=", secret, body)}},
+			conf:    Config{Secret: secret},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errResp := verifyWebhookSignature(body, tt.header, tt.conf)
+			if tt.wantErr && errResp == nil {
+				t.Fatalf("expected an error response, got nil")
+			}
+			if !tt.wantErr && errResp != nil {
+				t.Fatalf("expected no error response, got %+v", errResp)
+			}
+		})
+	}
+}
+
+func TestVerifyWebhookSignatureRequiresAHeader(t *testing.T) {
+	errResp := verifyWebhookSignature([]byte("body"), http.Header{}, Config{Secret: "s3cr3t"})
+	if errResp == nil {
+		t.Fatal("expected an error response when neither signature header is present")
+	}
+}
+
+func TestVerifyWebhookSignatureRequireSHA256RejectsSHA1(t *testing.T) {
+	body := []byte(`{"hello":"world"}`)
+	conf := Config{Secret: "s3cr3t", AllowLegacySHA1: true, RequireSHA256: true}
+	header := http.Header{"X-Hub-Signature": {sign(sha1.New, "sha1=", conf.Secret, body)}}
+	errResp := verifyWebhookSignature(body, header, conf)
+	if errResp == nil {
+		t.Fatal("expected RequireSHA256 to reject a SHA1-only delivery")
+	}
+}
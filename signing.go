@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/google/go-github/github"
+)
+
+const githubStatusSigningContext = "review/signing"
+
+// commitVerification is the part of a commit's GPG verification we care
+// about, fetched per-commit since the PR commit list doesn't include it.
+type commitVerification struct {
+	SHA      string
+	Verified bool
+	Reason   string
+}
+
+func verifyCommits(ctx context.Context, repository Repository, commits []*github.RepositoryCommit, repositories Repositories) ([]commitVerification, *ErrorResponse) {
+	verifications := make([]commitVerification, 0, len(commits))
+	for _, commit := range commits {
+		full, _, err := repositories.GetCommit(ctx, repository.Owner, repository.Name, *commit.SHA)
+		if err != nil {
+			message := fmt.Sprintf("Getting commit %s failed", *commit.SHA)
+			return nil, &ErrorResponse{err, http.StatusBadGateway, message}
+		}
+		verification := commitVerification{SHA: *commit.SHA, Reason: "unsigned"}
+		if v := full.Commit.Verification; v != nil {
+			if v.Verified != nil {
+				verification.Verified = *v.Verified
+			}
+			if v.Reason != nil {
+				verification.Reason = *v.Reason
+			}
+		}
+		verifications = append(verifications, verification)
+	}
+	return verifications, nil
+}
+
+func firstUnsigned(verifications []commitVerification) *commitVerification {
+	for _, verification := range verifications {
+		if !verification.Verified {
+			return &verification
+		}
+	}
+	return nil
+}
+
+func createSigningStatus(state, description string) *github.RepoStatus {
+	return &github.RepoStatus{
+		State:       github.String(state),
+		Description: github.String(description),
+		Context:     github.String(githubStatusSigningContext),
+	}
+}
+
+// signingStatusFor summarizes a PR's per-commit verification into a
+// single review/signing status, analogous to how includesFixupCommits
+// drives the review/squash status.
+func signingStatusFor(verifications []commitVerification) *github.RepoStatus {
+	if bad := firstUnsigned(verifications); bad != nil {
+		message := fmt.Sprintf("Commit %s is not verified (%s)", bad.SHA, bad.Reason)
+		return createSigningStatus("failure", message)
+	}
+	message := fmt.Sprintf("All %d commits are signed and verified", len(verifications))
+	return createSigningStatus("success", message)
+}
+
+// checkSignedCommits returns a non-nil Response with a review/squash
+// failure status already posted if any commit isn't verified; returns nil
+// if !squash is clear to proceed.
+func checkSignedCommits(ctx context.Context, repository Repository, headSHA string, verifications []commitVerification, repositories Repositories) Response {
+	bad := firstUnsigned(verifications)
+	if bad == nil {
+		return nil
+	}
+	message := fmt.Sprintf("Commit %s is not properly signed (%s). Please squash manually", bad.SHA, bad.Reason)
+	status := createSquashStatus("failure", message)
+	if errResp := setStatus(ctx, repository, headSHA, status, repositories); errResp != nil {
+		return errResp
+	}
+	return SuccessResponse{fmt.Sprintf("Refusing to squash: %s", message)}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/github"
+)
+
+func commitWithVerification(sha string, verified bool, reason string) *github.RepositoryCommit {
+	return &github.RepositoryCommit{
+		SHA: github.String(sha),
+		Commit: &github.Commit{
+			Verification: &github.SignatureVerification{
+				Verified: github.Bool(verified),
+				Reason:   github.String(reason),
+			},
+		},
+	}
+}
+
+func TestVerifyCommits(t *testing.T) {
+	repositories := &fakeRepositories{
+		commits: map[string]*github.RepositoryCommit{
+			"sha-good":        commitWithVerification("sha-good", true, "valid"),
+			"sha-unsigned":    commitWithVerification("sha-unsigned", false, "unsigned"),
+			"sha-unknown-key": commitWithVerification("sha-unknown-key", false, "unknown_key"),
+			"sha-bad-sig":     commitWithVerification("sha-bad-sig", false, "bad_signature"),
+		},
+	}
+	commits := []*github.RepositoryCommit{
+		{SHA: github.String("sha-good")},
+		{SHA: github.String("sha-unsigned")},
+		{SHA: github.String("sha-unknown-key")},
+		{SHA: github.String("sha-bad-sig")},
+	}
+
+	verifications, errResp := verifyCommits(context.Background(), Repository{}, commits, repositories)
+	if errResp != nil {
+		t.Fatalf("unexpected error: %+v", errResp)
+	}
+	want := []commitVerification{
+		{SHA: "sha-good", Verified: true, Reason: "valid"},
+		{SHA: "sha-unsigned", Verified: false, Reason: "unsigned"},
+		{SHA: "sha-unknown-key", Verified: false, Reason: "unknown_key"},
+		{SHA: "sha-bad-sig", Verified: false, Reason: "bad_signature"},
+	}
+	if len(verifications) != len(want) {
+		t.Fatalf("got %d verifications, want %d", len(verifications), len(want))
+	}
+	for i, v := range verifications {
+		if v != want[i] {
+			t.Errorf("verification %d = %+v, want %+v", i, v, want[i])
+		}
+	}
+}
+
+func TestCheckSignedCommits(t *testing.T) {
+	tests := []struct {
+		name          string
+		verifications []commitVerification
+		wantFailure   bool
+		wantReason    string
+	}{
+		{
+			name:          "all verified",
+			verifications: []commitVerification{{SHA: "a", Verified: true, Reason: "valid"}},
+		},
+		{
+			name:          "unsigned",
+			verifications: []commitVerification{{SHA: "a", Verified: false, Reason: "unsigned"}},
+			wantFailure:   true,
+			wantReason:    "unsigned",
+		},
+		{
+			name:          "unknown_key",
+			verifications: []commitVerification{{SHA: "a", Verified: false, Reason: "unknown_key"}},
+			wantFailure:   true,
+			wantReason:    "unknown_key",
+		},
+		{
+			name:          "bad_signature",
+			verifications: []commitVerification{{SHA: "a", Verified: false, Reason: "bad_signature"}},
+			wantFailure:   true,
+			wantReason:    "bad_signature",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repositories := &fakeRepositories{}
+			resp := checkSignedCommits(context.Background(), Repository{}, "headsha", tt.verifications, repositories)
+			if tt.wantFailure {
+				if resp == nil {
+					t.Fatal("expected a failure response, got nil")
+				}
+				status := repositories.lastStatus()
+				if status == nil || status.State == nil || *status.State != "failure" {
+					t.Fatalf("expected a failure review/squash status, got %+v", status)
+				}
+				if status.Description == nil || !strings.Contains(*status.Description, tt.wantReason) {
+					t.Errorf("status description %q doesn't mention reason %q", status.GetDescription(), tt.wantReason)
+				}
+			} else if resp != nil {
+				t.Fatalf("expected squashing to proceed, got %+v", resp)
+			}
+		})
+	}
+}
+
+func TestSigningStatusFor(t *testing.T) {
+	verified := []commitVerification{{SHA: "a", Verified: true, Reason: "valid"}}
+	status := signingStatusFor(verified)
+	if status.State == nil || *status.State != "success" {
+		t.Fatalf("expected success, got %+v", status)
+	}
+
+	unsigned := []commitVerification{
+		{SHA: "a", Verified: true, Reason: "valid"},
+		{SHA: "b", Verified: false, Reason: "unsigned"},
+	}
+	status = signingStatusFor(unsigned)
+	if status.State == nil || *status.State != "failure" {
+		t.Fatalf("expected failure, got %+v", status)
+	}
+	if status.Description == nil || !strings.Contains(*status.Description, "b") {
+		t.Errorf("status description %q doesn't name the offending commit", status.GetDescription())
+	}
+}